@@ -4,10 +4,14 @@
 package base
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/yuin/gluamapper"
 	"github.com/yuin/gopher-lua"
@@ -22,38 +26,148 @@ type LuaModuler interface {
 	Funcs() map[string]lua.LGFunction
 }
 
+// LuaConstanter can be implemented by a LuaModuler to expose typed constants,
+// they are converted by go2LuaValue and are read-only fields of the module.
+type LuaConstanter interface {
+	Constants() map[string]interface{}
+}
+
 func FuncModule(api LuaModuler) lua.LGFunction {
 	return func(L *lua.LState) int {
+		name := L.OptString(1, "")
 		t := L.NewTable()
 		for k, v := range api.Globals() {
 			t.RawSetString(k, lua.LString(v))
 		}
-		L.SetFuncs(t, api.Funcs())
-		mt := L.NewTable()
-		//Ignore the case of the function name
-		L.SetField(mt, "__index", L.NewFunction(func(L2 *lua.LState) int {
-			t := L2.CheckTable(1)
+		funcs := api.Funcs()
+		L.SetFuncs(t, funcs)
+
+		sigs := moduleSignatures(api)
+		st := L.CreateTable(0, len(funcs))
+		for k := range funcs {
+			st.RawSetString(k, lua.LString(moduleFuncUsage(name, k, sigs[k])))
+		}
+		t.RawSetString("__signatures", st)
+
+		var consts *lua.LTable
+		if c, ok := api.(LuaConstanter); ok {
+			consts = L.NewTable()
+			for k, v := range c.Constants() {
+				consts.RawSetString(k, go2LuaValue(L, reflect.ValueOf(v)))
+			}
+		}
+		L.SetMetatable(t, newModuleMetatable(L, consts))
+		L.Push(t)
+		return 1
+	}
+}
+
+// consts can be nil, a table of them is copied by constTable for every read
+func newModuleMetatable(L *lua.LState, consts *lua.LTable) *lua.LTable {
+	mt := L.NewTable()
+	constMt := L.NewTable()
+	L.SetField(constMt, "__newindex", L.NewFunction(func(L2 *lua.LState) int {
+		L2.ArgError(2, "You are not allowed to add field.")
+		return 0
+	}))
+	//Ignore the case of the function name
+	L.SetField(mt, "__index", L.NewFunction(func(L2 *lua.LState) int {
+		t := L2.CheckTable(1)
+		key := L2.CheckString(2)
+		if consts != nil && consts.RawGetString(key) != lua.LNil {
+			v := consts.RawGetString(key)
+			if data, ok := v.(*lua.LTable); ok {
+				v = constTable(L2, data, constMt, make(map[*lua.LTable]*lua.LTable))
+			}
+			L2.Push(v)
+			return 1
+		}
+		if f := t.RawGetString(strings.ToLower(key)); f.Type() == lua.LTFunction {
+			L2.Push(f)
+			return 1
+		} else {
+			L2.ArgError(2, "unknown func "+key+didYouMean(t, key))
+			return 0
+		}
+	}))
+	//Forbidden to add anything
+	L.SetField(mt, "__newindex", L.NewFunction(func(L2 *lua.LState) int {
+		if consts != nil && consts.RawGet(L2.CheckAny(2)) != lua.LNil {
+			L2.ArgError(2, fmt.Sprintf("constant %s is read-only.", L2.CheckAny(2)))
+			return 0
+		}
+		L2.ArgError(2, "You are not allowed to add field.")
+		return 0
+	}))
+	return mt
+}
+
+// a copy of the constant table data, so the data stays in the table for pairs, ipairs,
+// table.concat and unpack, and a write only changes the copy; mt forbids adding a key.
+// seen keeps the shared tables shared in the copy.
+func constTable(L *lua.LState, data, mt *lua.LTable, seen map[*lua.LTable]*lua.LTable) *lua.LTable {
+	if cp, ok := seen[data]; ok {
+		return cp
+	}
+	cp := L.CreateTable(data.Len(), 0)
+	seen[data] = cp
+	data.ForEach(func(k, v lua.LValue) {
+		if sub, ok := v.(*lua.LTable); ok {
+			v = constTable(L, sub, mt, seen)
+		}
+		cp.RawSet(k, v)
+	})
+	L.SetMetatable(cp, mt)
+	return cp
+}
+
+// LoadHelp registers the global 'help(module [, funcName])', which returns the usage
+// of the functions in a module created by FuncModule.
+func LoadHelp(L *lua.LState) {
+	L.SetGlobal("help", L.NewFunction(func(L2 *lua.LState) int {
+		t := L2.CheckTable(1)
+		st, ok := t.RawGetString("__signatures").(*lua.LTable)
+		if !ok {
+			L2.ArgError(1, "not a module created by FuncModule.")
+			return 0
+		}
+		if L2.GetTop() > 1 {
 			key := L2.CheckString(2)
-			if f := t.RawGetString(strings.ToLower(key)); f.Type() == lua.LTFunction {
-				L2.Push(f)
+			if usage := st.RawGetString(strings.ToLower(key)); usage != lua.LNil {
+				L2.Push(usage)
 				return 1
-			} else {
-				L2.ArgError(2, "unknown func "+key)
-				return 0
 			}
-		}))
-		//Forbidden to add anything
-		L.SetField(mt, "__newindex", L.NewFunction(func(L2 *lua.LState) int {
-			L2.ArgError(2, "You are not allowed to add field.")
+			L2.ArgError(2, "unknown func "+key+didYouMean(t, key))
 			return 0
-		}))
-		L.SetMetatable(t, mt)
-		L.Push(t)
+		}
+		lines := make([]string, 0)
+		st.ForEach(func(_, usage lua.LValue) {
+			lines = append(lines, usage.String())
+		})
+		sort.Strings(lines)
+		L2.Push(lua.LString(strings.Join(lines, "\n")))
 		return 1
-	}
+	}))
 }
 
 func ParseStruct(s interface{}, funcs map[string]lua.LGFunction) {
+	walkStruct(s, func(name string, v reflect.Value, tp reflect.Type, tag reflect.StructTag) {
+		funcs[name] = callWith(v, tp, parseCallOptions(tag))
+	})
+}
+
+// FuncSignatures returns the Go signature of every function ParseStruct would bind from s,
+// keyed by the same lower-cased names.
+func FuncSignatures(s interface{}) map[string]reflect.Type {
+	sigs := make(map[string]reflect.Type)
+	walkStruct(s, func(name string, v reflect.Value, tp reflect.Type, _ reflect.StructTag) {
+		sigs[name] = tp
+	})
+	return sigs
+}
+
+// tag is the tag of the struct field, empty for the methods
+func walkStruct(s interface{}, visit func(name string, v reflect.Value, tp reflect.Type, tag reflect.StructTag)) {
 	tpApi := reflect.TypeOf(s)
 	numField := 0
 	if tpApi.Kind() == reflect.Struct {
@@ -64,28 +178,31 @@ func ParseStruct(s interface{}, funcs map[string]lua.LGFunction) {
 			f := tpApi.Method(i)
 			v := reflect.ValueOf(s).MethodByName(f.Name)
 			if v.Kind() != reflect.Invalid {
-				funcs[strings.ToLower(f.Name)] = call(v, v.Type())
+				visit(strings.ToLower(f.Name), v, v.Type(), "")
 			}
 		}
 	}
 	for i := 0; i < numField; i++ {
 		f := tpApi.Field(i)
 		v := reflect.ValueOf(s).FieldByName(f.Name)
+		if !v.CanInterface() {
+			continue //ignore private fields
+		}
 		switch f.Type.Kind() {
 		case reflect.Ptr:
 			if v.Elem().Kind() == reflect.Struct {
 				v = v.Elem()
-				ParseStruct(v.Interface(), funcs)
+				walkStruct(v.Interface(), visit)
 			}
 		case reflect.Struct:
-			ParseStruct(v.Interface(), funcs)
+			walkStruct(v.Interface(), visit)
 		case reflect.Func:
-			funcs[strings.ToLower(f.Name)] = call(v, f.Type)
+			visit(strings.ToLower(f.Name), v, f.Type, f.Tag)
 		case reflect.String:
 			//
 		case reflect.Interface:
 			// KindOf v.Interface() is Ptr, will parse by range Methods
-			ParseStruct(v.Interface(), funcs)
+			walkStruct(v.Interface(), visit)
 		default:
 			//showField(f.Type.Name(), v)
 			logger.Warn("\t+++%v, name=%v", f.Type.Kind(), f.Type.Name())
@@ -150,6 +267,61 @@ func tipsUsage(tps reflect.Type) string {
 	return tips[:len(tips)-1] + ") or .new()"
 }
 
+func moduleFuncUsage(module, name string, tp reflect.Type) string {
+	if len(module) > 0 {
+		name = module + "." + name
+	}
+	if tp == nil {
+		return " Usage: " + name + "(...)"
+	}
+	return " Usage: " + funcSignature(name, tp)
+}
+
+// ", did you mean 'xxx'?" for the closest function name in the module
+func didYouMean(t *lua.LTable, key string) string {
+	key = strings.ToLower(key)
+	best, bestDist := "", len(key)/3+2
+	t.ForEach(func(k, v lua.LValue) {
+		if v.Type() != lua.LTFunction || k.Type() != lua.LTString {
+			return
+		}
+		if d := editDistance(key, k.String()); d < bestDist || (d == bestDist && k.String() < best) {
+			best, bestDist = k.String(), d
+		}
+	})
+	if len(best) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean '%s'?", best)
+}
+
+// Levenshtein distance
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
 func initGetterSetter(tps reflect.Type) map[string]lua.LGFunction {
 	fucs := map[string]lua.LGFunction{}
 	for i := 0; i < tps.NumField(); i++ {
@@ -181,6 +353,10 @@ func initGetterSetter(tps reflect.Type) map[string]lua.LGFunction {
 
 //==================================
 func call(f reflect.Value, fType reflect.Type) func(*lua.LState) int {
+	return callWith(f, fType, callOptions{})
+}
+
+func callWith(f reflect.Value, fType reflect.Type, opts callOptions) func(*lua.LState) int {
 	return func(L *lua.LState) int {
 		inputs, err := CheckGetInputs(L, fType)
 		if err != nil {
@@ -196,7 +372,7 @@ func call(f reflect.Value, fType reflect.Type) func(*lua.LState) int {
 		} else {
 			rets = f.CallSlice(inputs)
 		}
-		return CheckSetOutputs(L, fType, rets)
+		return setOutputs(L, fType, rets, opts)
 	}
 }
 
@@ -251,17 +427,9 @@ func CheckGetInputs(L *lua.LState, f reflect.Type) ([]reflect.Value, error) {
 	return inputs, nil
 }
 
+// CheckSetOutputs pushes the outputs of f by the convention in returns.go
 func CheckSetOutputs(L *lua.LState, f reflect.Type, vs []reflect.Value) int {
-	numOut := f.NumOut()
-
-	if len(vs) != numOut {
-		return SetErrorOutputs(L, numOut, fmt.Errorf("invalid outputs."))
-	}
-	i := 0
-	for ; i < numOut; i++ {
-		PushLValue(L, vs[i])
-	}
-	return numOut
+	return setOutputs(L, f, vs, callOptions{})
 }
 
 func SetErrorOutputs(L *lua.LState, numOut int, err error) int {
@@ -286,6 +454,37 @@ func lowerTypeName(v interface{}) string {
 // ret.Type()!=Slice expects[0]==ret.Type(); ret.Type()==Slice
 //     expects[0]=ret.Type().Elem() expects[1]=ret.Type()
 func ParseLValue(L *lua.LState, v lua.LValue, expects ...reflect.Type) (ret reflect.Value, err error) {
+	if cv, ok, cerr := customFromLua(L, v, expects[0]); ok {
+		return cv, cerr
+	}
+	if ud, ok := v.(*lua.LUserData); ok && ud.Value != nil {
+		// pass the golang value through, a *T is still the same pointer
+		uv := ud.Value
+		if p, ok := uv.(*goProxy); ok {
+			uv = p.rv.Interface()
+		}
+		for _, exp := range expects {
+			if reflect.TypeOf(uv).AssignableTo(exp) && exp.Kind() != reflect.Interface {
+				return reflect.ValueOf(uv), nil
+			}
+		}
+	}
+	if ch, ok := v.(lua.LChannel); ok && expects[0].Kind() == reflect.Chan {
+		return luaChan2Go(L, ch, expects[0])
+	}
+	if cv, ok, cerr := fallbackFromLua(L, v, expects[0]); ok {
+		return cv, cerr
+	}
+	if s, ok := v.(lua.LString); ok {
+		for _, exp := range expects {
+			switch {
+			case isBytesType(exp):
+				return parseBytes(string(s), exp)
+			case exp == bufferType:
+				return reflect.ValueOf(bytes.NewBufferString(string(s))), nil
+			}
+		}
+	}
 	ret = lua2GoValue(L, v)
 	if !ret.IsValid() {
 		if expects[0].Kind() == reflect.Interface {
@@ -295,44 +494,46 @@ func ParseLValue(L *lua.LState, v lua.LValue, expects ...reflect.Type) (ret refl
 		return
 	}
 
+	if ret.Kind() == reflect.Ptr {
+		ret = ret.Elem() //userdata
+	}
 	tp := ret.Type()
 	expKd := expects[0].Kind()
 
 	match := true
-	switch tp.Kind() {
-	case reflect.Int64:
-		n := reflect.Indirect(ret).Interface().(int64)
-		switch expKd {
-		case reflect.Int:
-			ret = reflect.ValueOf(int(n))
-		case reflect.Int8:
-			ret = reflect.ValueOf(int8(n))
-		case reflect.Int16:
-			ret = reflect.ValueOf(int16(n))
-		case reflect.Int32:
-			ret = reflect.ValueOf(int32(n))
-		case reflect.Int64:
-		case reflect.Uint:
-			ret = reflect.ValueOf(uint(n))
-		case reflect.Uint8:
-			ret = reflect.ValueOf(uint8(n))
-		case reflect.Uint16:
-			ret = reflect.ValueOf(uint16(n))
-		case reflect.Uint32:
-			ret = reflect.ValueOf(uint32(n))
-		case reflect.Uint64:
-			ret = reflect.ValueOf(uint64(n))
-		}
-	case reflect.Float64:
-		switch expKd {
-		case reflect.Float32:
-			n := reflect.Indirect(ret).Interface().(float64)
-			ret = reflect.ValueOf(float32(n))
-		case reflect.Float64:
+	switch {
+	case expects[0] == timeType:
+		var t time.Time
+		if t, err = parseTime(ret); err != nil {
+			return
+		}
+		ret = reflect.ValueOf(t)
+	case expects[0] == durationType:
+		var d time.Duration
+		if d, err = parseDuration(ret); err != nil {
+			return
+		}
+		ret = reflect.ValueOf(d)
+	case isIntegerKind(tp.Kind()) && isNumberKind(expKd):
+		// no wraparound, int64(-1) can't be uint
+		if ret, err = convertInteger(ret, expects[0]); err != nil {
+			return
+		}
+	case tp.Kind() == reflect.Float64 && (expKd == reflect.Float32 || expKd == reflect.Float64):
+		n := ret.Float()
+		ret = reflect.New(expects[0]).Elem()
+		if ret.OverflowFloat(n) {
+			err = fmt.Errorf("value %v out of range for %v.", n, expects[0])
+			return
+		}
+		ret.SetFloat(n)
+	case tp.Kind() == reflect.String && isIntegerKind(expKd) && settingsOf(L).integerMode == IntegerAsString:
+		// the integers pushed by IntegerAsString
+		if n, ok := parseIntegerString(ret.String()); ok {
+			if ret, err = convertInteger(n, expects[0]); err != nil {
+				return
+			}
 		}
-	case reflect.Ptr:
-		ret = ret.Elem()
-		tp = ret.Type()
 	default:
 		//reflect.Bool .String .Slice .Array .Map
 	}
@@ -351,49 +552,125 @@ func ParseLValue(L *lua.LState, v lua.LValue, expects ...reflect.Type) (ret refl
 }
 
 func lua2GoValue(L *lua.LState, v lua.LValue) (ret reflect.Value) {
-	switch v.Type() {
-	case lua.LTNil:
-		ret = reflect.ValueOf(nil)
-	case lua.LTBool:
-		ret = reflect.ValueOf(lua.LVAsBool(v))
-	case lua.LTNumber:
-		n := lua.LVAsNumber(v)
-		if float64(n) == float64(int64(n)) {
-			ret = reflect.ValueOf(int64(n))
-		} else {
-			ret = reflect.ValueOf(float64(n))
+	ret, err := (&luaToGo{}).value(v)
+	if err != nil {
+		L.ArgError(1, err.Error())
+	}
+	return
+}
+
+// the rules of lua2GoValue. A table is []T of its first element if it has one, otherwise
+// map[string]string; with isArray (json) it's []interface{} or map[string]interface{},
+// a proxy gives its golang value, and the cycles, NaN and Inf are rejected.
+type luaToGo struct {
+	isArray func(*lua.LTable) bool
+	seen    map[*lua.LTable]bool
+}
+
+func (c *luaToGo) value(v lua.LValue) (reflect.Value, error) {
+	switch lv := v.(type) {
+	case *lua.LNilType:
+		return reflect.ValueOf(nil), nil
+	case lua.LBool:
+		return reflect.ValueOf(bool(lv)), nil
+	case lua.LNumber:
+		if f := float64(lv); c.isArray != nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return reflect.Value{}, fmt.Errorf("unsupported number %v.", f)
+		}
+		if isIntegral(lv) {
+			return reflect.ValueOf(int64(lv)), nil
+		}
+		return reflect.ValueOf(float64(lv)), nil
+	case lua.LString:
+		return reflect.ValueOf(string(lv)), nil
+	case *lua.LTable:
+		if c.isArray != nil {
+			return c.generic(lv)
 		}
-	case lua.LTString:
-		ret = reflect.ValueOf(lua.LVAsString(v))
-	case lua.LTTable:
-		tmp := v.(*lua.LTable)
-		if tmp.Len() > 0 {
+		if lv.Len() > 0 {
 			//to Slice
-			first := reflect.Indirect(lua2GoValue(L, tmp.RawGetInt(1)))
-			tp := first.Type()
-			gv := reflect.MakeSlice(reflect.SliceOf(tp), tmp.Len(), tmp.Len())
+			first, err := c.value(lv.RawGetInt(1))
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			first = reflect.Indirect(first)
+			gv := reflect.MakeSlice(reflect.SliceOf(first.Type()), lv.Len(), lv.Len())
 			gv.Index(0).Set(first)
-			for i := 1; i < tmp.Len(); i++ {
-				others := reflect.Indirect(lua2GoValue(L, tmp.RawGetInt(i+1)))
-				gv.Index(i).Set(others)
+			for i := 1; i < lv.Len(); i++ {
+				others, err := c.value(lv.RawGetInt(i + 1))
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				gv.Index(i).Set(reflect.Indirect(others))
 			}
-			ret = gv
-		} else {
-			gv := make(map[string]string)
-			gluamapper.Map(v.(*lua.LTable), &gv)
-			ret = reflect.ValueOf(gv)
+			return gv, nil
+		}
+		gv := make(map[string]string)
+		gluamapper.Map(lv, &gv)
+		return reflect.ValueOf(gv), nil
+	case *lua.LUserData:
+		if c.isArray != nil {
+			if p, ok := lv.Value.(*goProxy); ok {
+				return p.rv, nil
+			}
+			return reflect.ValueOf(lv.Value), nil
 		}
-	case lua.LTUserData:
-		lv, _ := v.(*lua.LUserData)
 		tp := reflect.TypeOf(reflect.Indirect(reflect.ValueOf(lv.Value)).Interface())
 		rv := reflect.New(tp)
 		reflect.Indirect(rv).Set(reflect.Indirect(reflect.ValueOf(lv.Value)))
-		ret = rv // = reflect.ValueOf(lv.Value).Elem()
-	//case lua.LTFunction, lua.LTThread, lua.LTChannel:
-	default:
-		L.ArgError(1, "Unsupport type:"+v.Type().String())
+		return rv, nil // = reflect.ValueOf(lv.Value).Elem()
 	}
-	return
+	//lua.LTFunction, lua.LTThread, lua.LTChannel
+	return reflect.Value{}, fmt.Errorf("unsupported type %s.", v.Type())
+}
+
+// []interface{} or map[string]interface{} by isArray, nil for the nil elements
+func (c *luaToGo) generic(t *lua.LTable) (reflect.Value, error) {
+	if c.seen[t] {
+		return reflect.Value{}, fmt.Errorf("cycle in the table.")
+	}
+	if c.seen == nil {
+		c.seen = make(map[*lua.LTable]bool)
+	}
+	c.seen[t] = true
+	defer delete(c.seen, t)
+
+	elem := func(lv lua.LValue) (interface{}, error) {
+		rv, err := c.value(lv)
+		if err != nil || !rv.IsValid() {
+			return nil, err
+		}
+		return rv.Interface(), nil
+	}
+	if c.isArray(t) {
+		arr := make([]interface{}, t.Len())
+		for i := range arr {
+			v, err := elem(t.RawGetInt(i + 1))
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			arr[i] = v
+		}
+		return reflect.ValueOf(arr), nil
+	}
+	obj := make(map[string]interface{})
+	var err error
+	t.ForEach(func(k, lv lua.LValue) {
+		if err != nil {
+			return
+		}
+		switch k.(type) {
+		case lua.LString, lua.LNumber:
+		default:
+			err = fmt.Errorf("unsupported key type %s.", k.Type())
+			return
+		}
+		obj[k.String()], err = elem(lv)
+	})
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(obj), nil
 }
 
 /**
@@ -465,6 +742,10 @@ func PushLValue(L *lua.LState, vs ...reflect.Value) int {
 }
 
 func go2LuaValue(L *lua.LState, rv reflect.Value) lua.LValue {
+	return newConvState(L).go2LuaValue(L, rv, 0)
+}
+
+func (c *convState) go2LuaValue(L *lua.LState, rv reflect.Value, depth int) lua.LValue {
 	if !rv.IsValid() {
 		return lua.LNil
 	}
@@ -472,38 +753,37 @@ func go2LuaValue(L *lua.LState, rv reflect.Value) lua.LValue {
 	if v == nil {
 		return lua.LNil
 	}
+	if lv, ok := customToLua(L, rv); ok {
+		return lv
+	}
+	switch rv.Type() {
+	case timeType:
+		return time2LuaValue(L, v.(time.Time))
+	case durationType:
+		return duration2LuaValue(v.(time.Duration))
+	case bufferType:
+		return buffer2LuaValue(L, v.(*bytes.Buffer))
+	}
+	if lv, ok := fallbackToLua(L, rv); ok {
+		return lv
+	}
+	if isBytesType(rv.Type()) {
+		return lua.LString(rv.Bytes())
+	}
 	switch rv.Kind() {
 	case reflect.Bool:
-		return lua.LBool(v.(bool))
-	case reflect.Int:
-		return lua.LNumber(v.(int))
-	case reflect.Int8:
-		return lua.LNumber(v.(int8))
-	case reflect.Int16:
-		return lua.LNumber(v.(int16))
-	case reflect.Int32:
-		return lua.LNumber(v.(int32))
-	case reflect.Int64:
-		return lua.LNumber(v.(int64))
-	case reflect.Uint:
-		return lua.LNumber(v.(uint))
-	case reflect.Uint8:
-		return lua.LNumber(v.(uint8))
-	case reflect.Uint16:
-		return lua.LNumber(v.(uint16))
-	case reflect.Uint32:
-		return lua.LNumber(v.(uint32))
-	case reflect.Uint64:
-		return lua.LNumber(v.(uint64))
-	case reflect.Float32:
-		return lua.LNumber(v.(float32))
-	case reflect.Float64:
-		return lua.LNumber(v.(float64))
+		return lua.LBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int2LuaValue(L, rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uint2LuaValue(L, rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return lua.LNumber(rv.Float())
 	case reflect.String:
-		return lua.LString(v.(string))
+		return lua.LString(rv.String())
 	case reflect.Ptr:
 		//fmt.Printf("go2LuaValue ptr-->%v\n", v)
-		return go2LuaValue(L, rv.Elem())
+		return c.go2LuaValue(L, rv.Elem(), depth)
 	case reflect.Struct:
 		//第二个参数用 &v 会导致类型丢失，导致调用get set报错
 		//call of reflect.Value.FieldByName on interface Value
@@ -511,7 +791,10 @@ func go2LuaValue(L *lua.LState, rv reflect.Value) lua.LValue {
 		if L.GetTypeMetatable(lowerTypeName(v)) != nil {
 			return createUserData(L, rv.Interface(), lowerTypeName(v))
 		} else {
-			lt := L.CreateTable(0, rv.NumField())
+			if lt, ok := c.seen[c.key(rv)]; ok {
+				return lt //cycle
+			}
+			lt := c.newTable(L, rv, depth, 0, rv.NumField())
 			tpv := rv.Type()
 			for i := 0; i < rv.NumField(); i++ {
 				fn := tpv.Field(i).Name
@@ -519,27 +802,44 @@ func go2LuaValue(L *lua.LState, rv reflect.Value) lua.LValue {
 				if len(fn) == 0 || fn[0] < 'A' || fn[0] > 'Z' {
 					continue
 				}
-				lt.RawSetString(fn, go2LuaValue(L, rv.Field(i)))
+				c.count(L)
+				lt.RawSetString(fn, c.go2LuaValue(L, rv.Field(i), depth+1))
 			}
 			return lt
 		}
 	case reflect.Slice, reflect.Array:
-		lt := L.CreateTable(rv.Len(), 0)
+		if needProxy(rv, c.proxyThreshold) {
+			return NewProxy(L, v)
+		}
+		if lt, ok := c.seen[c.key(rv)]; ok {
+			return lt //cycle
+		}
+		lt := c.newTable(L, rv, depth, rv.Len(), 0)
 		for i := 0; i < rv.Len(); i++ {
-			lt.RawSetInt(i+1, go2LuaValue(L, rv.Index(i)))
+			c.count(L)
+			lt.RawSetInt(i+1, c.go2LuaValue(L, rv.Index(i), depth+1))
 		}
 		return lt
 	case reflect.Map:
-		lt := L.CreateTable(0, rv.Len())
+		if needProxy(rv, c.proxyThreshold) {
+			return NewProxy(L, v)
+		}
+		if lt, ok := c.seen[c.key(rv)]; ok {
+			return lt //cycle
+		}
+		lt := c.newTable(L, rv, depth, 0, rv.Len())
 		for _, k := range rv.MapKeys() {
-			lt.RawSet(go2LuaValue(L, k), go2LuaValue(L, rv.MapIndex(k)))
+			c.count(L)
+			lt.RawSet(c.go2LuaValue(L, k, depth+1), c.go2LuaValue(L, rv.MapIndex(k), depth+1))
 		}
 		return lt
+	case reflect.Chan:
+		return chan2LuaValue(L, rv)
 	case reflect.Interface:
 		if rv.Type() == errorInterface {
 			return lua.LString(reflect.Indirect(rv).Interface().(error).Error())
 		} else {
-			return go2LuaValue(L, reflect.ValueOf(rv.Interface()))
+			return c.go2LuaValue(L, reflect.ValueOf(rv.Interface()), depth)
 		}
 	default:
 		logger.Warn("default Go2LuaValue kind='%v', '%s', value='%v'", rv.Kind(), lowerTypeName(v), v)
@@ -3,6 +3,7 @@
 package base
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
@@ -90,3 +91,145 @@ func TestFuncModule(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestModuleHelp(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	x := &xt{
+		Double: func(n int) int { return n * n },
+		StrCat: func(s ...string) string { return strings.Join(s, "") },
+	}
+	L.PreloadModule("xt", FuncModule(x))
+	LoadHelp(L)
+
+	if err := L.DoString(`
+    xt = require("xt")
+    assert(xt.__signatures.double==' Usage: xt.double(int) int')
+    assert(help(xt, 'StrCat')==' Usage: xt.strcat(...string) string')
+    assert(help(xt)==' Usage: xt.double(int) int\n Usage: xt.strcat(...string) string')
+
+    local ok, err = pcall(function() return xt.dubble(2) end)
+    assert(not ok)
+    assert(string.find(err, "unknown func dubble, did you mean 'double'?", 1, true))
+    `); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type ct struct {
+	xt
+}
+
+func (c ct) Constants() map[string]interface{} {
+	return map[string]interface{}{
+		"MaxRetry": 3,
+		"Debug":    true,
+		"Levels":   map[string]int{"low": 1, "high": 9},
+		"Codes":    []int{10, 20, 30},
+	}
+}
+
+func TestModuleConstants(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	L.PreloadModule("ct", FuncModule(ct{}))
+
+	if err := L.DoString(`
+    ct = require("ct")
+    assert(ct.MaxRetry==3)
+    assert(ct.Debug==true)
+    assert(ct.Levels.high==9)
+    assert(ct.flag=='globelsFlag')
+
+    local ok, err = pcall(function() ct.MaxRetry = 4 end)
+    assert(not ok and string.find(err, "constant MaxRetry is read-only.", 1, true))
+    assert(ct.MaxRetry==3)
+
+    ok, err = pcall(function() ct.Levels.mid = 5 end)
+    assert(not ok and string.find(err, "You are not allowed to add field.", 1, true))
+    local levels = ct.Levels
+    levels.high = 10
+    assert(levels.high==10 and ct.Levels.high==9)
+
+    local n, sum = 0, 0
+    for i, c in ipairs(ct.Codes) do n = n + 1; sum = sum + c end
+    assert(n==3 and sum==60 and #ct.Codes==3)
+    assert(table.concat(ct.Codes, ",")=="10,20,30")
+    assert(select(3, unpack(ct.Codes))==30)
+    n, sum = 0, 0
+    for k, v in pairs(ct.Levels) do n = n + 1; sum = sum + v end
+    assert(n==2 and sum==10)
+    `); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type outputs struct {
+	Lookup func(string) (int, bool)
+	Div    func(int, int) (int, int, error)
+	Split  func(string) ([]string, error) `lua:"expand"`
+	Fields func(string) []string
+}
+
+func TestOutputs(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(outputs{
+		Lookup: func(k string) (int, bool) {
+			v, ok := map[string]int{"a": 1}[k]
+			return v, ok
+		},
+		Div: func(a, b int) (int, int, error) {
+			if b == 0 {
+				return 0, 0, errors.New("divide by zero")
+			}
+			return a / b, a % b, nil
+		},
+		Split: func(s string) ([]string, error) {
+			if len(s) == 0 {
+				return nil, errors.New("empty")
+			}
+			return strings.Split(s, ","), nil
+		},
+		Fields: strings.Fields,
+	}, funcs)
+	for k, f := range funcs {
+		L.SetGlobal(k, L.NewFunction(f))
+	}
+
+	if err := L.DoString(`
+    local v, ok = lookup("a")
+    assert(v==1 and ok==true)
+    v, ok = lookup("b")
+    assert(v==nil and ok==false)
+
+    local q, r, err = div(7, 2)
+    assert(q==3 and r==1 and err==nil)
+    q, r, err = div(7, 0)
+    assert(q==nil and r==nil and err=='divide by zero')
+
+    local a, b, c = split("x,y,z")
+    assert(a=='x' and b=='y' and c=='z')
+    assert(select('#', split("x"))==1)
+    a, b = split("")
+    assert(a==nil and b=='empty')
+
+    assert(#fields("x y")==2)
+    `); err != nil {
+		t.Fatal(err)
+	}
+
+	SetErrorPolicy(L, ErrorAsRaise)
+	if err := L.DoString(`
+    local ok, err = pcall(div, 7, 0)
+    assert(not ok and string.find(err, "divide by zero", 1, true))
+    local q, r, err = div(7, 2)
+    assert(q==3 and r==1 and err==nil)
+    `); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+//Lua.go
+
+//Binary-safe conversion of []byte, json.RawMessage and *bytes.Buffer
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/yuin/gopher-lua"
+)
+
+const bufferTypeName = "buffer"
+
+var (
+	bufferType     = reflect.TypeOf((*bytes.Buffer)(nil))
+	bytesType      = reflect.TypeOf([]byte(nil))
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func isBytesType(tp reflect.Type) bool {
+	return tp.Kind() == reflect.Slice && tp.Elem().Kind() == reflect.Uint8
+}
+
+// lua string --> []byte(or the named types of it, json.RawMessage must be valid json)
+func parseBytes(s string, expect reflect.Type) (reflect.Value, error) {
+	if expect == rawMessageType && !json.Valid([]byte(s)) {
+		return reflect.Value{}, fmt.Errorf("invalid json: %s", s)
+	}
+	if bytesType.ConvertibleTo(expect) {
+		return reflect.ValueOf([]byte(s)).Convert(expect), nil
+	}
+	// []MyByte, type MyByte uint8
+	rv := reflect.MakeSlice(expect, len(s), len(s))
+	for i := 0; i < len(s); i++ {
+		rv.Index(i).SetUint(uint64(s[i]))
+	}
+	return rv, nil
+}
+
+// the userdata 'buffer' shares the *bytes.Buffer with golang, no copy
+func buffer2LuaValue(L *lua.LState, buf *bytes.Buffer) lua.LValue {
+	if buf == nil {
+		return lua.LNil
+	}
+	if L.GetTypeMetatable(bufferTypeName) == lua.LNil {
+		RegisterBuffer(L)
+	}
+	ud := L.NewUserData()
+	ud.Value = buf
+	L.SetMetatable(ud, L.GetTypeMetatable(bufferTypeName))
+	return ud
+}
+
+// RegisterBuffer registers the global 'buffer': buffer.new([s]) and the methods of
+// userdata 'buffer'. It is called by go2LuaValue on the first *bytes.Buffer.
+func RegisterBuffer(L *lua.LState) {
+	mt := L.NewTypeMetatable(bufferTypeName)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"len": func(L2 *lua.LState) int {
+			L2.Push(lua.LNumber(checkBuffer(L2, 1).Len()))
+			return 1
+		},
+		// buf:byte(i), 1-based like string.byte
+		"byte": func(L2 *lua.LState) int {
+			b := checkBuffer(L2, 1).Bytes()
+			i := L2.OptInt(2, 1)
+			if i < 1 || i > len(b) {
+				return 0
+			}
+			L2.Push(lua.LNumber(b[i-1]))
+			return 1
+		},
+		// buf:sub(i [, j]), the same as string.sub
+		"sub": func(L2 *lua.LState) int {
+			b := checkBuffer(L2, 1).Bytes()
+			i, j := luaRange(L2.CheckInt(2), L2.OptInt(3, -1), len(b))
+			L2.Push(lua.LString(b[i:j]))
+			return 1
+		},
+		"write": func(L2 *lua.LState) int {
+			buf := checkBuffer(L2, 1)
+			for i := 2; i <= L2.GetTop(); i++ {
+				buf.WriteString(L2.CheckString(i))
+			}
+			return 0
+		},
+		"tostring": func(L2 *lua.LState) int {
+			L2.Push(lua.LString(checkBuffer(L2, 1).String()))
+			return 1
+		},
+		"reset": func(L2 *lua.LState) int {
+			checkBuffer(L2, 1).Reset()
+			return 0
+		},
+	}))
+	L.SetFuncs(mt, map[string]lua.LGFunction{
+		"__len": func(L2 *lua.LState) int {
+			L2.Push(lua.LNumber(checkBuffer(L2, 1).Len()))
+			return 1
+		},
+		"__tostring": func(L2 *lua.LState) int {
+			L2.Push(lua.LString(checkBuffer(L2, 1).String()))
+			return 1
+		},
+		"new": func(L2 *lua.LState) int {
+			L2.Push(buffer2LuaValue(L2, bytes.NewBufferString(L2.OptString(1, ""))))
+			return 1
+		},
+	})
+	L.SetGlobal(bufferTypeName, mt)
+}
+
+func checkBuffer(L *lua.LState, n int) *bytes.Buffer {
+	if buf, ok := L.CheckUserData(n).Value.(*bytes.Buffer); ok {
+		return buf
+	}
+	L.ArgError(n, "buffer expected")
+	return nil
+}
+
+// the indexes of string.sub(s, i, j) --> s[i:j] in golang
+func luaRange(i, j, l int) (int, int) {
+	if i < 0 {
+		i = l + i + 1
+	}
+	if j < 0 {
+		j = l + j + 1
+	}
+	if i < 1 {
+		i = 1
+	}
+	if j > l {
+		j = l
+	}
+	if i > j {
+		return 0, 0
+	}
+	return i - 1, j
+}
@@ -0,0 +1,69 @@
+//
+
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+type octet uint8
+
+func TestBytesConversion(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	shared := bytes.NewBufferString("\x00\x01head")
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(struct {
+		Reverse func([]byte) []byte
+		Raw     func(json.RawMessage) json.RawMessage
+		Shared  func() *bytes.Buffer
+		Append  func(*bytes.Buffer, string) int
+		Octets  func([]octet) int
+	}{
+		Reverse: func(b []byte) []byte {
+			r := make([]byte, len(b))
+			for i := range b {
+				r[len(b)-1-i] = b[i]
+			}
+			return r
+		},
+		Raw:    func(m json.RawMessage) json.RawMessage { return m },
+		Shared: func() *bytes.Buffer { return shared },
+		Append: func(b *bytes.Buffer, s string) int {
+			b.WriteString(s)
+			return b.Len()
+		},
+		Octets: func(b []octet) int { return int(b[len(b)-1]) },
+	}, funcs)
+	for k, f := range funcs {
+		L.SetGlobal(k, L.NewFunction(f))
+	}
+
+	if err := L.DoString(`
+    assert(reverse('ab\0c')=='c\0ba')
+    assert(raw('{"a":[1,2]}')=='{"a":[1,2]}')
+    assert(raw('{bad')=='invalid json: {bad')
+    assert(octets('ab\255')==255)
+
+    local buf = shared()
+    assert(#buf==6)
+    assert(buf:byte(1)==0 and buf:byte(2)==1)
+    assert(buf:sub(3)=='head')
+    buf:write('-lua')
+    assert(append(buf, '-go')==13)
+    assert(tostring(buf)=='\0\1head-lua-go')
+
+    local b2 = buffer.new('xyz')
+    assert(b2:sub(-2)=='yz')
+    `); err != nil {
+		t.Fatal(err)
+	}
+	if shared.String() != "\x00\x01head-lua-go" {
+		t.Fatalf("the buffer isn't shared: %q", shared.String())
+	}
+}
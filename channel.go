@@ -0,0 +1,313 @@
+//Lua.go
+
+//Golang channels in lua: userdata 'channel', and lua.LChannel where golang expects a channel
+package base
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/yuin/gopher-lua"
+)
+
+const channelTypeName = "channel"
+
+// the userdata 'channel' holds the golang channel itself, so it can be passed back
+func chan2LuaValue(L *lua.LState, rv reflect.Value) lua.LValue {
+	if rv.IsNil() {
+		return lua.LNil
+	}
+	if L.GetTypeMetatable(channelTypeName) == lua.LNil {
+		RegisterChannel(L)
+	}
+	ud := L.NewUserData()
+	ud.Value = rv.Interface()
+	L.SetMetatable(ud, L.GetTypeMetatable(channelTypeName))
+	return ud
+}
+
+// RegisterChannel registers the methods of userdata 'channel'. It is called by
+// go2LuaValue on the first golang channel.
+//
+//	for ev in events():iter() do print(ev.name) end
+//	local v, ok = ch:recv()   -- ok is false when ch is closed
+//	ch:send(v); ch:close()
+func RegisterChannel(L *lua.LState) {
+	mt := L.NewTypeMetatable(channelTypeName)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"recv": func(L2 *lua.LState) int {
+			v, ok := chanRecv(L2, checkChannel(L2, 1, reflect.RecvDir))
+			L2.Push(v)
+			L2.Push(lua.LBool(ok))
+			return 2
+		},
+		"send": func(L2 *lua.LState) int {
+			ch := checkChannel(L2, 1, reflect.SendDir)
+			v, err := ParseLValue(L2, L2.CheckAny(2), ch.Type().Elem())
+			if err != nil {
+				L2.ArgError(2, err.Error())
+			}
+			chanSend(L2, ch, proxyValue(v, ch.Type().Elem()))
+			return 0
+		},
+		"close": func(L2 *lua.LState) int {
+			ch := checkChannel(L2, 1, reflect.SendDir)
+			defer func() {
+				if r := recover(); r != nil {
+					L2.RaiseError("channel: %v", r)
+				}
+			}()
+			ch.Close()
+			return 0
+		},
+		// for v in ch:iter() do ... end, until the channel is closed
+		"iter": func(L2 *lua.LState) int {
+			ch := checkChannel(L2, 1, reflect.RecvDir)
+			L2.Push(L2.NewFunction(func(L3 *lua.LState) int {
+				v, ok := chanRecv(L3, ch)
+				if !ok {
+					return 0
+				}
+				L3.Push(v)
+				return 1
+			}))
+			return 1
+		},
+		"len": func(L2 *lua.LState) int {
+			L2.Push(lua.LNumber(checkChannel(L2, 1, 0).Len()))
+			return 1
+		},
+		"cap": func(L2 *lua.LState) int {
+			L2.Push(lua.LNumber(checkChannel(L2, 1, 0).Cap()))
+			return 1
+		},
+	}))
+	L.SetFuncs(mt, map[string]lua.LGFunction{
+		"__len": func(L2 *lua.LState) int {
+			L2.Push(lua.LNumber(checkChannel(L2, 1, 0).Len()))
+			return 1
+		},
+		"__tostring": func(L2 *lua.LState) int {
+			ch := checkChannel(L2, 1, 0)
+			L2.Push(lua.LString(fmt.Sprintf("channel: %v, len=%d", ch.Type(), ch.Len())))
+			return 1
+		},
+	})
+}
+
+// blocks until a value is received, the channel is closed or the context of L is done
+func chanRecv(L *lua.LState, ch reflect.Value) (lua.LValue, bool) {
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: ch}}
+	if ctx := L.Context(); ctx != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	}
+	chosen, v, ok := reflect.Select(cases)
+	if chosen == 1 {
+		L.RaiseError("channel: %v", L.Context().Err())
+	}
+	if !ok {
+		return lua.LNil, false
+	}
+	return go2LuaValue(L, v), true
+}
+
+func chanSend(L *lua.LState, ch reflect.Value, v reflect.Value) {
+	cases := []reflect.SelectCase{{Dir: reflect.SelectSend, Chan: ch, Send: v}}
+	if ctx := L.Context(); ctx != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			L.RaiseError("channel: %v", r) //send on closed channel
+		}
+	}()
+	if chosen, _, _ := reflect.Select(cases); chosen == 1 {
+		L.RaiseError("channel: %v", L.Context().Err())
+	}
+}
+
+// dir is the direction the method needs, 0 for any
+func checkChannel(L *lua.LState, n int, dir reflect.ChanDir) reflect.Value {
+	if ud, ok := L.Get(n).(*lua.LUserData); ok {
+		if ch := reflect.ValueOf(ud.Value); ch.Kind() == reflect.Chan {
+			if ch.Type().ChanDir()&dir != dir {
+				L.ArgError(n, fmt.Sprintf("%v can't be used to %v.", ch.Type(), dir))
+			}
+			return ch
+		}
+	}
+	L.ArgError(n, "channel expected")
+	return reflect.Value{}
+}
+
+// lua.LChannel --> chan T. A goroutine pumps the values between them without the
+// LState of the caller, so only nil, booleans, numbers, strings and the golang values
+// of userdata are passed, the others are dropped with a warning. The direction is
+// decided by expect: <-chan T and chan T get the values sent to the lua channel and
+// are closed after it is closed; the values written to chan<- T are queued for the lua
+// channel, so the golang function doesn't wait for lua, and the lua channel is closed
+// after it is closed. A pump stops early when the context of L is done.
+func luaChan2Go(L *lua.LState, ch lua.LChannel, expect reflect.Type) (reflect.Value, error) {
+	var done <-chan struct{}
+	if ctx := L.Context(); ctx != nil {
+		done = ctx.Done()
+	}
+	gch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, expect.Elem()), 0)
+	if expect.ChanDir() == reflect.SendDir {
+		go pumpToLua(gch, ch, settingsOf(L).integerMode, done)
+	} else {
+		go pumpToGo(ch, gch, done)
+	}
+	return gch.Convert(expect), nil
+}
+
+func pumpToGo(ch lua.LChannel, gch reflect.Value, done <-chan struct{}) {
+	defer func() {
+		if r := recover(); r != nil { //closed by golang
+			logger.Warn("luaChan2Go: %v", r)
+		}
+	}()
+	defer gch.Close()
+	elem := gch.Type().Elem()
+	for {
+		var (
+			lv lua.LValue
+			ok bool
+		)
+		select {
+		case lv, ok = <-ch:
+		case <-done:
+			return
+		}
+		if !ok {
+			return
+		}
+		v, err := plainFromLua(lv, elem)
+		if err != nil {
+			logger.Warn("luaChan2Go: drop the value '%v', %v", lv, err)
+			continue
+		}
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: gch, Send: v},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+		}
+		if chosen, _, _ := reflect.Select(cases); chosen == 1 {
+			return
+		}
+	}
+}
+
+func pumpToLua(gch reflect.Value, ch lua.LChannel, mode IntegerMode, done <-chan struct{}) {
+	defer func() {
+		if r := recover(); r != nil { //closed by lua
+			logger.Warn("luaChan2Go: %v", r)
+		}
+	}()
+	var queue []lua.LValue
+	for open := true; open || len(queue) > 0; {
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+			{Dir: reflect.SelectRecv}, //the zero Chan is ignored
+			{Dir: reflect.SelectSend},
+		}
+		if open {
+			cases[1].Chan = gch
+		}
+		if len(queue) > 0 {
+			cases[2].Chan, cases[2].Send = reflect.ValueOf(ch), reflect.ValueOf(&queue[0]).Elem()
+		}
+		chosen, v, ok := reflect.Select(cases)
+		switch {
+		case chosen == 0:
+			return
+		case chosen == 2:
+			queue = queue[1:]
+		case !ok:
+			open = false
+		default:
+			lv, err := plainToLua(v, mode)
+			if err != nil {
+				logger.Warn("luaChan2Go: drop the value '%v', %v", v, err)
+				continue
+			}
+			queue = append(queue, lv)
+		}
+	}
+	close(ch)
+}
+
+// lua --> golang for the pumps, without a LState
+func plainFromLua(lv lua.LValue, expect reflect.Type) (reflect.Value, error) {
+	var rv reflect.Value
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return reflect.Zero(expect), nil
+	case lua.LBool:
+		rv = reflect.ValueOf(bool(v))
+	case lua.LString:
+		rv = reflect.ValueOf(string(v))
+	case lua.LNumber:
+		if isIntegral(v) && isIntegerKind(expect.Kind()) {
+			return convertInteger(reflect.ValueOf(int64(v)), expect)
+		}
+		if isIntegral(v) && expect.Kind() == reflect.Interface {
+			rv = reflect.ValueOf(int64(v))
+		} else {
+			rv = reflect.ValueOf(float64(v))
+		}
+	case *lua.LUserData:
+		uv := v.Value
+		if p, ok := uv.(*goProxy); ok {
+			uv = p.rv.Interface()
+		}
+		if uv != nil {
+			rv = reflect.ValueOf(uv)
+		}
+	}
+	switch {
+	case !rv.IsValid():
+	case rv.Type().AssignableTo(expect):
+		return rv, nil
+	case rv.Kind() == expect.Kind() && rv.Type().ConvertibleTo(expect),
+		isNumberKind(rv.Kind()) && (expect.Kind() == reflect.Float32 || expect.Kind() == reflect.Float64):
+		return rv.Convert(expect), nil
+	}
+	return reflect.Value{}, fmt.Errorf("invalid value type, expect %v, got %s.", expect, lv.Type())
+}
+
+// golang --> lua for the pumps, without a LState
+func plainToLua(rv reflect.Value, mode IntegerMode) (lua.LValue, error) {
+	for rv.Kind() == reflect.Interface && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return lua.LBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := rv.Int()
+		if -maxExactInt <= n && n <= maxExactInt || mode == IntegerAsNumber {
+			return lua.LNumber(n), nil
+		} else if mode == IntegerAsString {
+			return lua.LString(strconv.FormatInt(n, 10)), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := rv.Uint()
+		if n <= maxExactInt || mode == IntegerAsNumber {
+			return lua.LNumber(n), nil
+		} else if mode == IntegerAsString {
+			return lua.LString(strconv.FormatUint(n, 10)), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		return lua.LNumber(rv.Float()), nil
+	case reflect.String:
+		return lua.LString(rv.String()), nil
+	case reflect.Invalid:
+		return lua.LNil, nil
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if rv.IsNil() {
+			return lua.LNil, nil
+		}
+	}
+	return nil, fmt.Errorf("%v can't be passed by a lua channel.", rv.Type())
+}
@@ -0,0 +1,134 @@
+//
+
+package base
+
+import (
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestChannel(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	var got, live []int
+	streamed, release, wrote := make(chan struct{}), make(chan struct{}), make(chan struct{}, 2)
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(struct {
+		Events  func(n int) <-chan string
+		Numbers func() chan int
+		Consume func(<-chan int) int
+		Produce func(chan<- int)
+		Stream  func(<-chan int)
+	}{
+		Events: func(n int) <-chan string {
+			ch := make(chan string, n)
+			for i := 0; i < n; i++ {
+				ch <- "ev"
+			}
+			close(ch)
+			return ch
+		},
+		Numbers: func() chan int { return make(chan int, 3) },
+		Consume: func(ch <-chan int) int {
+			for n := range ch {
+				got = append(got, n)
+			}
+			return len(got)
+		},
+		Produce: func(ch chan<- int) {
+			for i := 1; i <= 3; i++ {
+				ch <- i * 10
+			}
+			close(ch)
+		},
+		Stream: func(ch <-chan int) {
+			go func() {
+				defer close(streamed)
+				for n := range ch {
+					live = append(live, n)
+				}
+			}()
+		},
+	}, funcs)
+	funcs["writeLater"] = Proc1(func(ch chan<- int) {
+		go func() {
+			defer func() { wrote <- struct{}{} }()
+			ch <- 7
+			close(ch)
+		}()
+	})
+	funcs["closeLater"] = Proc1(func(ch chan<- int) {
+		go func() {
+			defer func() { wrote <- struct{}{} }()
+			<-release
+			close(ch)
+		}()
+	})
+	for k, f := range funcs {
+		L.SetGlobal(k, L.NewFunction(f))
+	}
+
+	if err := L.DoString(`
+    local n = 0
+    for ev in events(3):iter() do assert(ev=='ev'); n = n + 1 end
+    assert(n==3)
+
+    local ch = numbers()
+    ch:send(1); ch:send(2)
+    assert(not pcall(ch.send, ch, "3"))
+    assert(#ch==2 and ch:cap()==3)
+    local v, ok = ch:recv()
+    assert(v==1 and ok)
+    ch:close()
+    assert(ch:recv()==2)
+    v, ok = ch:recv()
+    assert(v==nil and not ok)
+    assert(not pcall(ch.send, ch, 3))
+    assert(not pcall(events(1).send, events(1), 'x'))
+
+    local lch = channel.make(3)
+    lch:send(1); lch:send(2); lch:close()
+    assert(consume(lch)==2)
+
+    -- a live stream, sent after the call
+    local live = channel.make()
+    stream(live)
+    live:send(5); live:send(6); live:close()
+
+    -- unbuffered, the values are queued until lua receives them
+    local out = channel.make()
+    produce(out)
+    local sum = 0
+    while true do
+        local ok, v = out:receive()
+        if not ok then break end
+        sum = sum + v
+    end
+    assert(sum==60)
+
+    -- the generic binding, written after the call
+    local later = channel.make()
+    writeLater(later)
+    local ok, v = later:receive()
+    assert(ok and v==7)
+    assert(not later:receive())
+
+    -- closed by lua before golang closes it
+    local gone = channel.make()
+    closeLater(gone)
+    gone:close()
+    `); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("the lua channel wasn't passed: %v", got)
+	}
+	if <-streamed; len(live) != 2 || live[0] != 5 || live[1] != 6 {
+		t.Fatalf("the stream: %v", live)
+	}
+	close(release)
+	<-wrote
+	<-wrote
+}
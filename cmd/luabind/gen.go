@@ -0,0 +1,468 @@
+//Lua.go
+
+//Generate the static lua bindings of a golang package
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const generatedHeader = "// Code generated by luabind; DO NOT EDIT."
+
+// the numbers are checked and pushed by golang-luaApi: no fraction, no wraparound,
+// and the IntegerMode of the LState
+const apiImport = `luaapi "github.com/qhbsyys/golang-luaApi"`
+
+// basic golang type --> (lua type, expr to check the n-th argument, expr to push a value)
+var basicTypes = map[string]struct {
+	lua   string
+	check string
+	push  string
+}{
+	"string":  {"lua.LString", "L.CheckString(%s)", "lua.LString(%s)"},
+	"bool":    {"lua.LBool", "L.CheckBool(%s)", "lua.LBool(%s)"},
+	"int":     {"lua.LNumber", "luaapi.CheckNumber[int](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"int8":    {"lua.LNumber", "luaapi.CheckNumber[int8](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"int16":   {"lua.LNumber", "luaapi.CheckNumber[int16](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"int32":   {"lua.LNumber", "luaapi.CheckNumber[int32](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"rune":    {"lua.LNumber", "luaapi.CheckNumber[rune](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"int64":   {"lua.LNumber", "luaapi.CheckNumber[int64](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"uint":    {"lua.LNumber", "luaapi.CheckNumber[uint](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"uint8":   {"lua.LNumber", "luaapi.CheckNumber[uint8](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"byte":    {"lua.LNumber", "luaapi.CheckNumber[byte](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"uint16":  {"lua.LNumber", "luaapi.CheckNumber[uint16](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"uint32":  {"lua.LNumber", "luaapi.CheckNumber[uint32](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"uint64":  {"lua.LNumber", "luaapi.CheckNumber[uint64](L, %s)", "luaapi.IntegerValue(L, %s)"},
+	"float32": {"lua.LNumber", "luaapi.CheckNumber[float32](L, %s)", "lua.LNumber(%s)"},
+	"float64": {"lua.LNumber", "float64(L.CheckNumber(%s))", "lua.LNumber(%s)"},
+}
+
+type goType struct {
+	name  string // int, Point
+	ptr   bool   // *Point
+	slice bool   // []int
+	err   bool   // error
+}
+
+func (t goType) String() string {
+	switch {
+	case t.err:
+		return "error"
+	case t.ptr:
+		return "*" + t.name
+	case t.slice:
+		return "[]" + t.name
+	}
+	return t.name
+}
+
+type generator struct {
+	pkg     string
+	module  string
+	funcs   []*ast.FuncDecl
+	consts  []string
+	structs map[string]*ast.StructType
+	helpers map[string]string
+	errs    []string
+	buf     bytes.Buffer
+}
+
+// generate the bindings of the package in dir, skipping the file 'output'
+func generate(dir, module, output string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != filepath.Base(output)
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("Expect one package in %s, got %d.", dir, len(pkgs))
+	}
+
+	g := &generator{module: module, structs: map[string]*ast.StructType{}, helpers: map[string]string{}}
+	for name, pkg := range pkgs {
+		g.pkg = name
+		g.collect(pkg)
+	}
+	g.emit()
+	if len(g.errs) > 0 {
+		return nil, fmt.Errorf("unsupported declarations:\n\t%s", strings.Join(g.errs, "\n\t"))
+	}
+	return format.Source(g.buf.Bytes())
+}
+
+func (g *generator) collect(pkg *ast.Package) {
+	files := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	for _, name := range files {
+		f := pkg.Files[name]
+		if len(f.Comments) > 0 && strings.HasPrefix(f.Comments[0].Text(), generatedHeader[3:]) {
+			continue
+		}
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.IsExported() && !ignored(d.Doc) {
+					g.funcs = append(g.funcs, d)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.ValueSpec:
+						if d.Tok != token.CONST {
+							continue
+						}
+						// untyped or string constants with a string literal
+						if id, ok := s.Type.(*ast.Ident); s.Type != nil && (!ok || id.Name != "string") {
+							continue
+						}
+						for i, n := range s.Names {
+							if lit, ok := valueAt(s.Values, i).(*ast.BasicLit); ok && lit.Kind == token.STRING && n.IsExported() {
+								g.consts = append(g.consts, n.Name)
+							}
+						}
+					case *ast.TypeSpec:
+						if st, ok := s.Type.(*ast.StructType); ok && s.Name.IsExported() && !ignored(d.Doc) {
+							g.structs[s.Name.Name] = st
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(g.funcs, func(i, j int) bool { return g.funcs[i].Name.Name < g.funcs[j].Name.Name })
+	sort.Strings(g.consts)
+}
+
+func (g *generator) emit() {
+	g.printf("// %s implements LuaModuler of github.com/qhbsyys/golang-luaApi.\n", g.module)
+	g.printf("type %s struct{}\n\n", g.module)
+
+	g.printf("func (%s) Globals() map[string]string {\n\treturn map[string]string{\n", g.module)
+	for _, c := range g.consts {
+		g.printf("\t\t%q: %s,\n", strings.ToLower(c), c)
+	}
+	g.printf("\t}\n}\n\n")
+
+	g.printf("func (%s) Funcs() map[string]lua.LGFunction {\n\treturn map[string]lua.LGFunction{\n", g.module)
+	for _, f := range g.funcs {
+		g.printf("\t\t%q: lua%s,\n", strings.ToLower(f.Name.Name), f.Name.Name)
+	}
+	g.printf("\t}\n}\n\n")
+
+	names := make([]string, 0, len(g.structs))
+	for name := range g.structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	g.printf("// RegisterTypes registers the metatables of the userdata used by %s.\n", g.module)
+	g.printf("func (%s) RegisterTypes(L *lua.LState) {\n", g.module)
+	for _, name := range names {
+		g.printf("\tluaRegister%s(L)\n", name)
+	}
+	g.printf("}\n\n")
+
+	for _, f := range g.funcs {
+		g.emitFunc(f)
+	}
+	for _, name := range names {
+		g.emitStruct(name, g.structs[name])
+	}
+
+	helpers := make([]string, 0, len(g.helpers))
+	for name := range g.helpers {
+		helpers = append(helpers, name)
+	}
+	sort.Strings(helpers)
+	for _, name := range helpers {
+		g.printf("%s\n", g.helpers[name])
+	}
+
+	// the import of golang-luaApi only if it's used
+	body := append([]byte(nil), g.buf.Bytes()...)
+	g.buf.Reset()
+	g.printf("%s\n\npackage %s\n\nimport (\n\t\"github.com/yuin/gopher-lua\"\n", generatedHeader, g.pkg)
+	if bytes.Contains(body, []byte("luaapi.")) {
+		g.printf("\t%s\n", apiImport)
+	}
+	g.printf(")\n\n")
+	g.buf.Write(body)
+}
+
+func (g *generator) emitFunc(f *ast.FuncDecl) {
+	var (
+		body  bytes.Buffer
+		args  []string
+		n     = 0
+		where = "func " + f.Name.Name
+	)
+	for _, field := range f.Type.Params.List {
+		names := len(field.Names)
+		if names == 0 {
+			names = 1
+		}
+		for j := 0; j < names; j++ {
+			n++
+			arg := fmt.Sprintf("a%d", n)
+			if ell, ok := field.Type.(*ast.Ellipsis); ok {
+				tp, ok := g.resolve(ell.Elt, where)
+				if !ok {
+					continue
+				}
+				fmt.Fprintf(&body, "\t%s := make([]%s, 0, L.GetTop())\n", arg, tp)
+				fmt.Fprintf(&body, "\tfor i := %d; i <= L.GetTop(); i++ {\n\t\t%s = append(%s, %s)\n\t}\n",
+					n, arg, arg, g.checkExpr(tp, "i"))
+				args = append(args, arg+"...")
+				continue
+			}
+			tp, ok := g.resolve(field.Type, where)
+			if !ok {
+				continue
+			}
+			if tp.err {
+				g.errs = append(g.errs, where+": error parameter")
+				continue
+			}
+			fmt.Fprintf(&body, "\t%s := %s\n", arg, g.checkExpr(tp, fmt.Sprint(n)))
+			args = append(args, arg)
+		}
+	}
+
+	var outs []goType
+	if f.Type.Results != nil {
+		for _, field := range f.Type.Results.List {
+			names := len(field.Names)
+			if names == 0 {
+				names = 1
+			}
+			tp, ok := g.resolve(field.Type, where)
+			for j := 0; j < names && ok; j++ {
+				outs = append(outs, tp)
+			}
+		}
+	}
+	rets := make([]string, len(outs))
+	for i := range outs {
+		rets[i] = fmt.Sprintf("r%d", i+1)
+	}
+
+	g.printf("func lua%s(L *lua.LState) int {\n", f.Name.Name)
+	g.buf.Write(body.Bytes())
+	call := fmt.Sprintf("%s(%s)", f.Name.Name, strings.Join(args, ", "))
+	if len(outs) == 0 {
+		g.printf("\t%s\n\treturn 0\n}\n\n", call)
+		return
+	}
+	g.printf("\t%s := %s\n", strings.Join(rets, ", "), call)
+	if last := outs[len(outs)-1]; last.err {
+		// the same as SetErrorOutputs: nil..., "error"
+		g.printf("\tif r%d != nil {\n", len(outs))
+		for i := 0; i < len(outs)-1; i++ {
+			g.printf("\t\tL.Push(lua.LNil)\n")
+		}
+		g.printf("\t\tL.Push(lua.LString(r%d.Error()))\n\t\treturn %d\n\t}\n", len(outs), len(outs))
+	}
+	for i, tp := range outs {
+		if tp.err {
+			g.printf("\tL.Push(lua.LNil)\n")
+		} else {
+			g.printf("\tL.Push(%s)\n", g.pushExpr(tp, rets[i]))
+		}
+	}
+	g.printf("\treturn %d\n}\n\n", len(outs))
+}
+
+func (g *generator) emitStruct(name string, st *ast.StructType) {
+	mtName := strings.ToLower(name)
+	where := "type " + name
+
+	g.printf("func luaRegister%s(L *lua.LState) {\n", name)
+	g.printf("\tmt := L.NewTypeMetatable(%q)\n", mtName)
+	g.printf("\tL.SetField(mt, \"__index\", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{\n")
+	var fields []string
+	var types []goType
+	for _, field := range st.Fields.List {
+		if field.Tag != nil && strings.Contains(field.Tag.Value, `lua:"-"`) {
+			continue
+		}
+		if len(field.Names) == 0 {
+			g.errs = append(g.errs, where+": embedded field")
+			continue
+		}
+		for _, n := range field.Names {
+			if !n.IsExported() {
+				continue //ignore private fields
+			}
+			tp, ok := g.resolve(field.Type, where+"."+n.Name)
+			if !ok {
+				continue
+			}
+			fields = append(fields, n.Name)
+			types = append(types, tp)
+			g.printf("\t\t\"get%s\": func(L *lua.LState) int {\n", n.Name)
+			g.printf("\t\t\tL.Push(%s)\n\t\t\treturn 1\n\t\t},\n", g.pushExpr(tp, "luaCheck"+name+"(L, 1)."+n.Name))
+			g.printf("\t\t\"set%s\": func(L *lua.LState) int {\n", n.Name)
+			g.printf("\t\t\tluaCheck%s(L, 1).%s = %s\n\t\t\treturn 0\n\t\t},\n", name, n.Name, g.checkExpr(tp, "2"))
+		}
+	}
+	g.printf("\t}))\n")
+	g.printf("\tL.SetField(mt, \"new\", L.NewFunction(func(L *lua.LState) int {\n\t\tv := &%s{}\n", name)
+	for i, f := range fields {
+		g.printf("\t\tif L.GetTop() >= %d {\n\t\t\tv.%s = %s\n\t\t}\n", i+1, f, g.checkExpr(types[i], fmt.Sprint(i+1)))
+	}
+	g.printf("\t\tL.Push(luaNew%s(L, v))\n\t\treturn 1\n\t}))\n", name)
+	g.printf("\tL.SetGlobal(%q, mt)\n}\n\n", mtName)
+
+	g.printf("func luaNew%s(L *lua.LState, v *%s) *lua.LUserData {\n", name, name)
+	g.printf("\tud := L.NewUserData()\n\tud.Value = v\n\tL.SetMetatable(ud, L.GetTypeMetatable(%q))\n\treturn ud\n}\n\n", mtName)
+
+	g.printf("func luaCheck%s(L *lua.LState, n int) *%s {\n", name, name)
+	g.printf("\tswitch v := L.CheckUserData(n).Value.(type) {\n\tcase *%s:\n\t\treturn v\n\tcase %s:\n\t\treturn &v\n\t}\n", name, name)
+	g.printf("\tL.ArgError(n, %q)\n\treturn nil\n}\n\n", mtName+" expected")
+}
+
+// golang type of the ast expr, false for unsupported types
+func (g *generator) resolve(expr ast.Expr, where string) (goType, bool) {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if e.Name == "error" {
+			return goType{err: true}, true
+		}
+		if _, ok := basicTypes[e.Name]; ok {
+			return goType{name: e.Name}, true
+		}
+		if _, ok := g.structs[e.Name]; ok {
+			return goType{name: e.Name}, true
+		}
+	case *ast.StarExpr:
+		if id, ok := e.X.(*ast.Ident); ok {
+			if _, ok := g.structs[id.Name]; ok {
+				return goType{name: id.Name, ptr: true}, true
+			}
+		}
+	case *ast.ArrayType:
+		if id, ok := e.Elt.(*ast.Ident); ok && e.Len == nil {
+			if _, ok := basicTypes[id.Name]; ok {
+				return goType{name: id.Name, slice: true}, true
+			}
+		}
+	}
+	var b bytes.Buffer
+	format.Node(&b, token.NewFileSet(), expr)
+	g.errs = append(g.errs, fmt.Sprintf("%s: type %s", where, b.String()))
+	return goType{}, false
+}
+
+// expr of type 'tp' from the n-th argument
+func (g *generator) checkExpr(tp goType, n string) string {
+	switch {
+	case tp.slice && basicTypes[tp.name].lua == "lua.LNumber":
+		name := "luaCheckSlice" + upperFirst(tp.name)
+		g.helpers[name] = fmt.Sprintf(`func %s(L *lua.LState, n int) []%s {
+	tb := L.CheckTable(n)
+	ret := make([]%s, 0, tb.Len())
+	for i := 1; i <= tb.Len(); i++ {
+		v, err := luaapi.ParseNumber[%s](L, tb.RawGetInt(i))
+		if err != nil {
+			L.ArgError(n, "[]%s expected, "+err.Error())
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+`, name, tp.name, tp.name, tp.name, tp.name)
+		return fmt.Sprintf("%s(L, %s)", name, n)
+	case tp.slice:
+		name := "luaCheckSlice" + upperFirst(tp.name)
+		bt := basicTypes[tp.name]
+		g.helpers[name] = fmt.Sprintf(`func %s(L *lua.LState, n int) []%s {
+	tb := L.CheckTable(n)
+	ret := make([]%s, 0, tb.Len())
+	for i := 1; i <= tb.Len(); i++ {
+		lv, ok := tb.RawGetInt(i).(%s)
+		if !ok {
+			L.ArgError(n, "[]%s expected")
+		}
+		ret = append(ret, %s(lv))
+	}
+	return ret
+}
+`, name, tp.name, tp.name, bt.lua, tp.name, tp.name)
+		return fmt.Sprintf("%s(L, %s)", name, n)
+	case tp.ptr:
+		return fmt.Sprintf("luaCheck%s(L, %s)", tp.name, n)
+	case g.structs[tp.name] != nil:
+		return fmt.Sprintf("*luaCheck%s(L, %s)", tp.name, n)
+	}
+	return fmt.Sprintf(basicTypes[tp.name].check, n)
+}
+
+// lua value of 'v' with type 'tp'
+func (g *generator) pushExpr(tp goType, v string) string {
+	switch {
+	case tp.slice:
+		name := "luaSlice" + upperFirst(tp.name)
+		g.helpers[name] = fmt.Sprintf(`func %s(L *lua.LState, s []%s) *lua.LTable {
+	tb := L.CreateTable(len(s), 0)
+	for i, v := range s {
+		tb.RawSetInt(i+1, %s)
+	}
+	return tb
+}
+`, name, tp.name, fmt.Sprintf(basicTypes[tp.name].push, "v"))
+		return fmt.Sprintf("%s(L, %s)", name, v)
+	case tp.ptr:
+		return fmt.Sprintf("luaNew%s(L, %s)", tp.name, v)
+	case g.structs[tp.name] != nil:
+		name := "luaValue" + tp.name
+		g.helpers[name] = fmt.Sprintf(`func %s(L *lua.LState, v %s) *lua.LUserData {
+	return luaNew%s(L, &v)
+}
+`, name, tp.name, tp.name)
+		return fmt.Sprintf("%s(L, %s)", name, v)
+	}
+	return fmt.Sprintf(basicTypes[tp.name].push, v)
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+//------------------------------
+// '//luabind:ignore' in the doc excludes the declaration
+func ignored(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.HasPrefix(c.Text, "//luabind:ignore") {
+			return true
+		}
+	}
+	return false
+}
+
+func upperFirst(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func valueAt(values []ast.Expr, i int) ast.Expr {
+	if i < len(values) {
+		return values[i]
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePkg(t *testing.T, src string) string {
+	dir, err := ioutil.TempDir("", "luabind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "geo.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestGenerate(t *testing.T) {
+	dir := writePkg(t, `package geo
+
+const Version = "1.0"
+
+type Point struct {
+	X, Y  float64
+	Tags  []string
+	cache int
+}
+
+func Add(a, b int) int { return a + b }
+
+func Move(p *Point, dx float64) (Point, error) { return *p, nil }
+
+func Sum(ns ...int) int { return 0 }
+
+//luabind:ignore
+func Skip(m map[string]int) {}
+`)
+	defer os.RemoveAll(dir)
+
+	src, err := generate(dir, "GeoModule", filepath.Join(dir, "lua_bind.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(src)
+	for _, want := range []string{
+		"// Code generated by luabind; DO NOT EDIT.",
+		"type GeoModule struct{}",
+		`"version": Version,`,
+		`"add":  luaAdd,`,
+		"a1 := luaapi.CheckNumber[int](L, 1)",
+		"r1, r2 := Move(a1, a2)",
+		"L.Push(lua.LString(r2.Error()))",
+		"a1 = append(a1, luaapi.CheckNumber[int](L, i))",
+		"L.Push(luaapi.IntegerValue(L, r1))",
+		`mt := L.NewTypeMetatable("point")`,
+		`"setTags": func(L *lua.LState) int {`,
+		"func luaCheckSliceString(L *lua.LState, n int) []string {",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code missing %q:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "Skip") || strings.Contains(code, "cache") {
+		t.Errorf("ignored declarations were generated:\n%s", code)
+	}
+}
+
+func TestGenerateUnsupported(t *testing.T) {
+	dir := writePkg(t, `package geo
+
+func Keys(m map[string]int) []string { return nil }
+`)
+	defer os.RemoveAll(dir)
+
+	_, err := generate(dir, "GeoModule", filepath.Join(dir, "lua_bind.go"))
+	if err == nil || !strings.Contains(err.Error(), "func Keys: type map[string]int") {
+		t.Fatalf("expect unsupported type error, got %v", err)
+	}
+}
+
+// the generated bindings are built with the package and run against a LState
+func TestGeneratedBindings(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("go is required to build the bindings")
+	}
+	// in the module, so the package can import golang-luaApi
+	os.MkdirAll("testdata", 0755)
+	defer os.Remove("testdata")
+	dir, err := ioutil.TempDir("testdata", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(`package main
+
+import (
+	"fmt"
+	"os"
+
+	luaapi "github.com/qhbsyys/golang-luaApi"
+	"github.com/yuin/gopher-lua"
+)
+
+func Small(n uint8) uint8       { return n }
+func Big(n uint64) uint64       { return n }
+func Narrow(ns []int8) []int8   { return ns }
+func Half(f float32) float32    { return f / 2 }
+func Count(ns ...uint16) uint16 { return uint16(len(ns)) }
+
+func main() {
+	L := lua.NewState()
+	defer L.Close()
+	luaapi.SetIntegerMode(L, luaapi.IntegerAsString)
+	L.PreloadModule("geo", luaapi.FuncModule(GeoModule{}))
+	if err := L.DoString(os.Args[1]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(dir, "GeoModule", filepath.Join(dir, "lua_bind.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "lua_bind.go"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goBin, "run", ".", `
+local geo = require("geo")
+assert(geo.small(200) == 200)
+assert(not pcall(geo.small, 300))
+assert(not pcall(geo.small, -1))
+assert(not pcall(geo.small, 1.5))
+assert(not pcall(geo.big, -1))
+assert(geo.big("18446744073709551615") == "18446744073709551615")
+assert(geo.narrow({1, -2, 3})[2] == -2)
+assert(not pcall(geo.narrow, {1, 200}))
+assert(not pcall(geo.narrow, {1, 1.5}))
+assert(geo.half(3) == 1.5)
+assert(not pcall(geo.half, 1e300))
+assert(geo.count(1, 2, 3) == 3)
+assert(not pcall(geo.count, 1, 70000))
+`)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s\n%s", err, out, src)
+	}
+}
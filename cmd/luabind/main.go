@@ -0,0 +1,42 @@
+//Lua.go
+
+// luabind writes static lua bindings for the exported funcs, string constants and
+// structs of a golang package, as an alternative of ParseStruct/RegisterUserData.
+//
+//	//go:generate luabind -module LuaModule -o lua_bind.go
+//
+// Unsupported types fail the generation, add '//luabind:ignore' to the doc of a
+// func or type, or the tag `lua:"-"` to a field, to exclude it. The numbers are
+// converted by golang-luaApi, with the range checks and the IntegerMode of ParseLValue.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	var (
+		dir    = flag.String("dir", ".", "directory of the package")
+		module = flag.String("module", "LuaModule", "name of the generated module type")
+		output = flag.String("o", "lua_bind.go", "output file, relative to -dir")
+	)
+	flag.Parse()
+
+	out := *output
+	if !filepath.IsAbs(out) {
+		out = filepath.Join(*dir, out)
+	}
+	src, err := generate(*dir, *module, out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "luabind:", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(out, src, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "luabind:", err)
+		os.Exit(1)
+	}
+}
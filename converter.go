@@ -0,0 +1,148 @@
+//Lua.go
+
+//Custom conversions of the golang types, consulted before the kind switch of go2LuaValue/ParseLValue
+package base
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/yuin/gopher-lua"
+)
+
+type converter struct {
+	toLua   func(L *lua.LState, rv reflect.Value) lua.LValue
+	fromLua func(L *lua.LState, lv lua.LValue) (reflect.Value, error)
+}
+
+var (
+	converters = make(map[reflect.Type]converter)
+	convLock   sync.RWMutex
+
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	stringerType        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// RegisterConverter registers the conversions of T, either of them can be nil.
+//
+//	RegisterConverter(func(L *lua.LState, d decimal.Decimal) lua.LValue {
+//		return lua.LString(d.String())
+//	}, func(L *lua.LState, lv lua.LValue) (decimal.Decimal, error) {
+//		return decimal.NewFromString(lv.String())
+//	})
+//
+// Without a converter, the opaque types (no exported fields and not registered
+// by RegisterUserData) are converted by encoding.TextMarshaler/TextUnmarshaler,
+// or fmt.Stringer to lua only.
+func RegisterConverter[T any](toLua func(*lua.LState, T) lua.LValue, fromLua func(*lua.LState, lua.LValue) (T, error)) {
+	tp := reflect.TypeOf((*T)(nil)).Elem()
+	var c converter
+	if toLua != nil {
+		c.toLua = func(L *lua.LState, rv reflect.Value) lua.LValue {
+			return toLua(L, rv.Interface().(T))
+		}
+	}
+	if fromLua != nil {
+		c.fromLua = func(L *lua.LState, lv lua.LValue) (reflect.Value, error) {
+			v, err := fromLua(L, lv)
+			return reflect.ValueOf(&v).Elem(), err
+		}
+	}
+
+	convLock.Lock()
+	defer convLock.Unlock()
+	converters[tp] = c
+}
+
+func getConverter(tp reflect.Type) (converter, bool) {
+	convLock.RLock()
+	defer convLock.RUnlock()
+	c, ok := converters[tp]
+	return c, ok
+}
+
+func customToLua(L *lua.LState, rv reflect.Value) (lua.LValue, bool) {
+	if c, ok := getConverter(rv.Type()); ok && c.toLua != nil {
+		return c.toLua(L, rv), true
+	}
+	return nil, false
+}
+
+// TextMarshaler and Stringer, after the builtin conversions of time, []byte...
+func fallbackToLua(L *lua.LState, rv reflect.Value) (lua.LValue, bool) {
+	tp := rv.Type()
+	if !isOpaqueType(L, tp) {
+		return nil, false
+	}
+	if tp.Implements(textMarshalerType) {
+		if tp.Kind() == reflect.Ptr && rv.IsNil() {
+			return lua.LNil, true
+		}
+		if text, err := rv.Interface().(encoding.TextMarshaler).MarshalText(); err == nil {
+			return lua.LString(text), true
+		}
+	}
+	// Stringer only for the kinds which the default conversions can't present
+	switch tp.Kind() {
+	case reflect.Struct, reflect.Array:
+		if tp.Implements(stringerType) {
+			return lua.LString(rv.Interface().(fmt.Stringer).String()), true
+		}
+	}
+	return nil, false
+}
+
+func customFromLua(L *lua.LState, lv lua.LValue, expect reflect.Type) (reflect.Value, bool, error) {
+	if c, ok := getConverter(expect); ok && c.fromLua != nil {
+		rv, err := c.fromLua(L, lv)
+		return rv, true, err
+	}
+	if expect.Kind() == reflect.Ptr {
+		if c, ok := getConverter(expect.Elem()); ok && c.fromLua != nil {
+			rv, err := c.fromLua(L, lv)
+			if err != nil {
+				return rv, true, err
+			}
+			ptr := reflect.New(expect.Elem())
+			ptr.Elem().Set(rv)
+			return ptr, true, nil
+		}
+	}
+	return reflect.Value{}, false, nil
+}
+
+// TextUnmarshaler for lua strings
+func fallbackFromLua(L *lua.LState, lv lua.LValue, expect reflect.Type) (reflect.Value, bool, error) {
+	s, ok := lv.(lua.LString)
+	if !ok || !isOpaqueType(L, expect) {
+		return reflect.Value{}, false, nil
+	}
+	switch {
+	case expect.Kind() == reflect.Ptr && expect.Implements(textUnmarshalerType):
+		ptr := reflect.New(expect.Elem())
+		err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return ptr, true, err
+	case expect.Kind() != reflect.Interface && reflect.PtrTo(expect).Implements(textUnmarshalerType):
+		ptr := reflect.New(expect)
+		err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return ptr.Elem(), true, err
+	}
+	return reflect.Value{}, false, nil
+}
+
+// no exported fields and not registered by RegisterUserData
+func isOpaqueType(L *lua.LState, tp reflect.Type) bool {
+	if tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if tp.Kind() != reflect.Struct {
+		return true
+	}
+	if L.GetTypeMetatable(lowerTypeName(reflect.Zero(tp).Interface())) != lua.LNil {
+		return false
+	}
+	return len(exportedFields(tp)) == 0
+}
@@ -0,0 +1,53 @@
+//
+
+package base
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+type orderID struct{ shard, seq int }
+
+type money struct{ cents int64 }
+
+func (m money) String() string { return fmt.Sprintf("%d.%02d", m.cents/100, m.cents%100) }
+
+func TestConverters(t *testing.T) {
+	RegisterConverter(func(L *lua.LState, id orderID) lua.LValue {
+		return lua.LString(fmt.Sprintf("%d-%d", id.shard, id.seq))
+	}, func(L *lua.LState, lv lua.LValue) (id orderID, err error) {
+		_, err = fmt.Sscanf(lv.String(), "%d-%d", &id.shard, &id.seq)
+		return
+	})
+
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(struct {
+		NextID func(orderID) orderID
+		Mask   func(net.IP) net.IP
+		Price  func() money
+	}{
+		NextID: func(id orderID) orderID { return orderID{id.shard, id.seq + 1} },
+		Mask:   func(ip net.IP) net.IP { return ip.Mask(net.CIDRMask(24, 32)) },
+		Price:  func() money { return money{1999} },
+	}, funcs)
+	for k, f := range funcs {
+		L.SetGlobal(k, L.NewFunction(f))
+	}
+
+	if err := L.DoString(`
+    assert(nextid('3-41')=='3-42')
+    assert(string.find(nextid('bad'), 'expected integer'))
+    assert(mask('192.168.1.77')=='192.168.1.0')
+    assert(price()=='19.99')
+    assert(string.find(mask('not-an-ip'), 'invalid IP', 1, true))
+    `); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+//Lua.go
+
+//Limit the conversion of golang values to lua, and keep the references of the cycles
+package base
+
+import (
+	"reflect"
+
+	"github.com/yuin/gopher-lua"
+)
+
+const defaultConvDepth = 100
+
+// SetConvertLimits limits the tables go2LuaValue creates for one value in L,
+// 0 is unlimited. Exceeding a limit raises a lua error. By default the depth
+// is limited to 100 and the elements are unlimited.
+func SetConvertLimits(L *lua.LState, maxDepth, maxElements int) {
+	s := settingsOf(L)
+	s.maxConvDepth = maxDepth
+	s.maxConvElements = maxElements
+}
+
+// maps, slices and addressable structs are identified by their address,
+// converting one twice returns the same table so cycles end
+type convKey struct {
+	ptr uintptr
+	len int
+	tp  reflect.Type
+}
+
+type convState struct {
+	seen     map[convKey]lua.LValue
+	elements int
+
+	maxDepth       int // nested tables
+	maxElements    int // entries of all the tables
+	proxyThreshold int
+}
+
+func newConvState(L *lua.LState) *convState {
+	s := settingsOf(L)
+	return &convState{seen: make(map[convKey]lua.LValue), maxDepth: s.maxConvDepth,
+		maxElements: s.maxConvElements, proxyThreshold: s.proxyThreshold}
+}
+
+func (c *convState) key(rv reflect.Value) convKey {
+	switch rv.Kind() {
+	case reflect.Map:
+		return convKey{rv.Pointer(), 0, rv.Type()}
+	case reflect.Slice:
+		return convKey{rv.Pointer(), rv.Len(), rv.Type()}
+	case reflect.Struct, reflect.Array:
+		if rv.CanAddr() {
+			return convKey{rv.Addr().Pointer(), 0, rv.Type()}
+		}
+	}
+	return convKey{}
+}
+
+func (c *convState) newTable(L *lua.LState, rv reflect.Value, depth, acap, hcap int) *lua.LTable {
+	if c.maxDepth > 0 && depth >= c.maxDepth {
+		L.RaiseError("go2LuaValue: %v nested too deep, the limit is %d.", rv.Type(), c.maxDepth)
+	}
+	lt := L.CreateTable(acap, hcap)
+	if k := c.key(rv); k.ptr != 0 {
+		c.seen[k] = lt
+	}
+	return lt
+}
+
+func (c *convState) count(L *lua.LState) {
+	c.elements++
+	if c.maxElements > 0 && c.elements > c.maxElements {
+		L.RaiseError("go2LuaValue: too many elements, the limit is %d.", c.maxElements)
+	}
+}
@@ -0,0 +1,63 @@
+//
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestConvertCycles(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	self := map[string]interface{}{"name": "root"}
+	self["self"] = self
+	list := []interface{}{1, nil}
+	list[1] = list
+
+	deep := map[string]interface{}{}
+	for i, cur := 0, deep; i < 10; i++ {
+		next := map[string]interface{}{}
+		cur["next"] = next
+		cur = next
+	}
+
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(struct {
+		Self func() map[string]interface{}
+		List func() []interface{}
+		Deep func() map[string]interface{}
+		Big  func() []int
+	}{
+		Self: func() map[string]interface{} { return self },
+		List: func() []interface{} { return list },
+		Deep: func() map[string]interface{} { return deep },
+		Big:  func() []int { return make([]int, 100) },
+	}, funcs)
+	for k, f := range funcs {
+		L.SetGlobal(k, L.NewFunction(f))
+	}
+
+	if err := L.DoString(`
+    local s = self()
+    assert(s.self == s and s.self.self.name == 'root')
+    local l = list()
+    assert(l[2] == l and l[1] == 1)
+    `); err != nil {
+		t.Fatal(err)
+	}
+
+	SetConvertLimits(L, 5, 50)
+	if err := L.DoString(`deep()`); err == nil || !strings.Contains(err.Error(), "nested too deep") {
+		t.Fatalf("expect depth error, got %v", err)
+	}
+	if err := L.DoString(`big()`); err == nil || !strings.Contains(err.Error(), "too many elements") {
+		t.Fatalf("expect elements error, got %v", err)
+	}
+	if err := L.DoString(`assert(self().name=='root')`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,314 @@
+//Lua.go
+
+//The runner of a crontab job: its own timer, the overlap policy and the status
+package base
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+const (
+	OverlapSkip  = "skip"  // don't start if the previous run is still running
+	OverlapQueue = "queue" // run after the previous run, up to maxQueuedRuns are kept
+	OverlapAllow = "allow" // run at once, the default like the runners of robfig/cron
+)
+
+const maxQueuedRuns = 10 // the others are skipped
+
+func validOverlap(overlap string) bool {
+	switch overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapAllow:
+		return true
+	}
+	return false
+}
+
+const maxRunHistory = 20 // the recent runs kept for every job
+
+// RunRecord is a run of a job, Error is "" if it succeeded
+type RunRecord struct {
+	Start, End time.Time
+	Attempts   int // 1 + the retries
+	Error      string
+}
+
+// JobStatus is the status of a job in the crontab
+type JobStatus struct {
+	ID        string
+	Spec      string
+	Next      time.Time // zero if the job will never run again
+	Paused    bool      // the scheduled runs are ignored, RunNow still works
+	Running   int       // the runs in progress
+	Queued    int       // the runs waiting for the previous one, overlap='queue'
+	Skipped   int64     // the runs skipped, overlap='skip' or the queue is full
+	Elsewhere int64     // the ticks run by the other replicas, singleton=true
+
+	LastStart          time.Time // the start of the last run, it may be in progress
+	LastEnd            time.Time // the end of the last finished run, like the fields below
+	LastDuration       time.Duration
+	LastError          string
+	Successes          int64
+	Failures           int64
+	Recent             []RunRecord // the recent runs, the oldest first
+}
+
+// the part of the status which is saved to Crontab.HistoryFile
+type jobHistory struct {
+	Skipped   int64
+	Elsewhere int64
+	Successes int64
+	Failures  int64
+	Recent    []RunRecord
+}
+
+// the history of a job, an updated job takes it over from the replaced cronJob, so
+// the runs still in progress of the replaced one are recorded too
+type runLog struct {
+	mu sync.Mutex
+	jobHistory
+	started time.Time // the start of the last run, before it's recorded
+}
+
+func (l *runLog) snapshot() jobHistory {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := l.jobHistory
+	h.Recent = append([]RunRecord(nil), h.Recent...)
+	return h
+}
+
+type cronJob struct {
+	RawJob
+	cmd   func(run jobRun) error
+	stop  chan struct{}
+	done  func() // called after every run
+	retry retryPolicy
+	lease func(scheduled time.Time) bool // nil if it isn't singleton
+
+	mu      sync.Mutex
+	paused  bool
+	next    time.Time
+	running int
+	queued  []time.Time // the scheduled times of the queued runs
+
+	logOnce sync.Once
+	log     *runLog // set by Crontab.schedule, or created by history
+}
+
+func (cj *cronJob) history() *runLog {
+	cj.logOnce.Do(func() {
+		if cj.log == nil {
+			cj.log = &runLog{}
+		}
+	})
+	return cj.log
+}
+
+func (cj *cronJob) loop(sched cron.Schedule) {
+	for {
+		next := sched.Next(time.Now())
+		cj.mu.Lock()
+		cj.next = next
+		cj.mu.Unlock()
+		if next.IsZero() {
+			return //never
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			cj.tick(cj.cmd, next)
+		case <-cj.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// the job is due, start it by the overlap policy unless it's paused
+func (cj *cronJob) tick(cmd func(jobRun) error, scheduled time.Time) {
+	cj.start(cmd, scheduled, false)
+}
+
+// manual is true for RunNow, which ignores the pause and isn't counted as skipped
+func (cj *cronJob) start(cmd func(jobRun) error, scheduled time.Time, manual bool) error {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	if cj.paused && !manual {
+		return nil
+	}
+	// before the overlap policy, so the queued ticks are leased too. The lease may be
+	// file I/O, it's taken without cj.mu so status and the runs don't wait for it.
+	if !manual && cj.lease != nil {
+		cj.mu.Unlock()
+		ok := cj.lease(scheduled)
+		cj.mu.Lock()
+		if !ok {
+			log := cj.history()
+			log.count(&log.Elsewhere)
+			return nil
+		}
+	}
+	if cj.running > 0 {
+		switch cj.overlap() {
+		case OverlapAllow:
+		case OverlapQueue:
+			if len(cj.queued) < maxQueuedRuns {
+				cj.queued = append(cj.queued, scheduled)
+				return nil
+			}
+			if manual {
+				return fmt.Errorf("Task %s has %d queued runs already.", cj.ID(), len(cj.queued))
+			}
+			log := cj.history()
+			log.count(&log.Skipped)
+			logger.Warn("task %s has %d queued runs, skip this run", cj.ID(), len(cj.queued))
+			return nil
+		default:
+			if manual {
+				return fmt.Errorf("Task %s is still running.", cj.ID())
+			}
+			log := cj.history()
+			log.count(&log.Skipped)
+			logger.Warn("task %s is still running, skip this run", cj.ID())
+			return nil
+		}
+	}
+	cj.running++
+	go cj.drain(cmd, scheduled)
+	return nil
+}
+
+// allow by default; the runs of a lvm>0 job can't overlap, so they are queued instead
+// of waiting for the lvm in their goroutines
+func (cj *cronJob) overlap() string {
+	switch {
+	case cj.LvmId > 0 && cj.Overlap != OverlapSkip:
+		return OverlapQueue
+	case cj.Overlap == "":
+		return OverlapAllow
+	}
+	return cj.Overlap
+}
+
+// run, then the queued runs unless the job is stopped
+func (cj *cronJob) drain(cmd func(jobRun) error, scheduled time.Time) {
+	for {
+		cj.run(cmd, scheduled)
+
+		cj.mu.Lock()
+		select {
+		case <-cj.stop:
+			cj.queued = nil
+		default:
+		}
+		if len(cj.queued) == 0 {
+			cj.running--
+			cj.mu.Unlock()
+			return
+		}
+		scheduled, cj.queued = cj.queued[0], cj.queued[1:]
+		cj.mu.Unlock()
+	}
+}
+
+// run cmd, and retry it by the retry policy unless the job is stopped
+func (cj *cronJob) run(cmd func(jobRun) error, scheduled time.Time) {
+	log := cj.history()
+	rec := RunRecord{Start: time.Now()}
+	log.mu.Lock()
+	log.started = rec.Start
+	log.mu.Unlock()
+	var err error
+	for {
+		rec.Attempts++
+		if err = cj.attempt(cmd, cj.newRun(scheduled, rec.Attempts)); err == nil || !cj.retry.shouldRetry(rec.Attempts, err) {
+			break
+		}
+		wait := cj.retry.backoff(rec.Attempts)
+		logger.Warn("task %s failed, retry in %v: %v", cj.ID(), wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			continue
+		case <-cj.stop:
+			timer.Stop()
+		}
+		break
+	}
+	rec.End = time.Now()
+	if err != nil {
+		rec.Error = err.Error()
+		logger.Error("task %s failed: %v", cj.ID(), err)
+	}
+
+	log.mu.Lock()
+	if err != nil {
+		log.Failures++
+	} else {
+		log.Successes++
+	}
+	log.Recent = append(log.Recent, rec)
+	if len(log.Recent) > maxRunHistory {
+		log.Recent = append([]RunRecord(nil), log.Recent[len(log.Recent)-maxRunHistory:]...)
+	}
+	log.mu.Unlock()
+
+	if cj.done != nil {
+		cj.done()
+	}
+}
+
+func (cj *cronJob) attempt(cmd func(jobRun) error, run jobRun) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return cmd(run)
+}
+
+func (l *runLog) count(n *int64) {
+	l.mu.Lock()
+	*n++
+	l.mu.Unlock()
+}
+
+func (cj *cronJob) snapshot() jobHistory {
+	return cj.history().snapshot()
+}
+
+func (cj *cronJob) status() JobStatus {
+	log := cj.history()
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	h := log.snapshot()
+	st := JobStatus{
+		ID:        cj.ID(),
+		Spec:      cj.Spec(),
+		Next:      cj.next,
+		Paused:    cj.paused,
+		Running:   cj.running,
+		Queued:    len(cj.queued),
+		Skipped:   h.Skipped,
+		Elsewhere: h.Elsewhere,
+		Successes: h.Successes,
+		Failures:  h.Failures,
+		Recent:    h.Recent,
+	}
+	if len(h.Recent) > 0 {
+		last := h.Recent[len(h.Recent)-1]
+		st.LastStart, st.LastEnd = last.Start, last.End
+		st.LastDuration = last.End.Sub(last.Start)
+		st.LastError = last.Error
+	}
+	log.mu.Lock()
+	if log.started.After(st.LastStart) {
+		st.LastStart = log.started
+	}
+	log.mu.Unlock()
+	return st
+}
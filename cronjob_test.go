@@ -0,0 +1,180 @@
+//
+
+package base
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestJobOverlap(t *testing.T) {
+	for _, tc := range []struct {
+		overlap                  string
+		runs, skipped, maxAtOnce int32
+	}{
+		{"", 3, 0, 3},
+		{OverlapSkip, 1, 2, 1},
+		{OverlapQueue, 3, 0, 1},
+		{OverlapAllow, 3, 0, 3},
+	} {
+		var runs, now, maxAtOnce int32
+		release := make(chan struct{})
+		cmd := func(jobRun) error {
+			n := atomic.AddInt32(&now, 1)
+			for {
+				m := atomic.LoadInt32(&maxAtOnce)
+				if n <= m || atomic.CompareAndSwapInt32(&maxAtOnce, m, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&now, -1)
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}
+		cj := &cronJob{RawJob: RawJob{Id: "j", Overlap: tc.overlap}, stop: make(chan struct{})}
+		for i := 0; i < 3; i++ {
+			cj.tick(cmd, time.Now())
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		for i := 0; i < 100 && cj.status().Running > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		st := cj.status()
+		if runs != tc.runs || st.Skipped != int64(tc.skipped) || maxAtOnce != tc.maxAtOnce || st.Running != 0 {
+			t.Fatalf("overlap=%q: runs=%d, maxAtOnce=%d, status=%+v", tc.overlap, runs, maxAtOnce, st)
+		}
+	}
+
+	// the queue is capped, the other ticks are skipped
+	release := make(chan struct{})
+	cj := &cronJob{RawJob: RawJob{Id: "q", Overlap: OverlapQueue}, stop: make(chan struct{})}
+	for i := 0; i < maxQueuedRuns+3; i++ {
+		cj.tick(func(jobRun) error { <-release; return nil }, time.Now())
+	}
+	if st := cj.status(); st.Queued != maxQueuedRuns || st.Skipped != 2 {
+		t.Fatalf("capped queue: %+v", st)
+	}
+	if err := cj.start(func(jobRun) error { return nil }, time.Now(), true); err == nil {
+		t.Fatal("expect RunNow to fail with the full queue")
+	}
+	close(release)
+
+	if (RawJob{RawSpec: "@hourly", Overlap: "wait"}).Valid() == nil {
+		t.Fatal("expect the invalid overlap")
+	}
+
+	// the runs in the same lvm wait for each other even with overlap='allow'
+	script := filepath.Join(t.TempDir(), "job.lua")
+	os.WriteFile(script, []byte("enter()"), 0644)
+	var now, maxAtOnce int32
+	plf := func(L *lua.LState) error {
+		L.Register("enter", func(L *lua.LState) int {
+			if n := atomic.AddInt32(&now, 1); n > atomic.LoadInt32(&maxAtOnce) {
+				atomic.StoreInt32(&maxAtOnce, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&now, -1)
+			return 0
+		})
+		return nil
+	}
+	rj := RawJob{Id: "vm", Overlap: OverlapAllow, LvmId: 5, FilePath: script}
+	cj = &cronJob{RawJob: rj, stop: make(chan struct{})}
+	for i := 0; i < 3; i++ {
+		cj.tick(func(run jobRun) error { return rj.runScript(run, plf, nil) }, time.Now())
+	}
+	if st := cj.status(); st.Running != 1 || st.Queued != 2 {
+		t.Fatalf("the lvm runs are queued, not waiting in goroutines: %+v", st)
+	}
+	for i := 0; i < 100 && cj.status().Successes < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := cj.status(); st.Successes != 3 || maxAtOnce != 1 {
+		t.Fatalf("lvm runs: maxAtOnce=%d, status=%+v", maxAtOnce, st)
+	}
+}
+
+func TestJobHistory(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history.json")
+	ct := Crontab{HistoryFile: historyFile, jobs: make(map[string]*cronJob)}
+	cj := &cronJob{RawJob: RawJob{Id: "j", RawSpec: "@hourly"}, stop: make(chan struct{}), done: ct.saveHistory}
+	ct.jobs["j"] = cj
+
+	for i := 0; i < maxRunHistory+5; i++ {
+		cj.run(func(jobRun) error { return nil }, time.Now())
+	}
+	cj.run(func(jobRun) error { return errors.New("boom") }, time.Now())
+	cj.run(func(jobRun) error { panic("oops") }, time.Now())
+
+	st := ct.Status()[0]
+	if st.Successes != maxRunHistory+5 || st.Failures != 2 || len(st.Recent) != maxRunHistory {
+		t.Fatalf("counts: %+v", st)
+	}
+	if st.LastError != "panic: oops" || st.Recent[len(st.Recent)-2].Error != "boom" {
+		t.Fatalf("errors: %+v", st)
+	}
+	if st.LastStart.IsZero() || st.LastEnd.Before(st.LastStart) || st.LastDuration < 0 {
+		t.Fatalf("times: %+v", st)
+	}
+
+	// restored by the first Load of a new Crontab
+	ct2 := Crontab{HistoryFile: historyFile}
+	h := ct2.loadHistory()["j"]
+	if h.Successes != maxRunHistory+5 || h.Failures != 2 || len(h.Recent) != maxRunHistory {
+		t.Fatalf("history: %+v", h)
+	}
+}
+
+func TestJobRetry(t *testing.T) {
+	rj := RawJob{Id: "r", RawSpec: "@hourly", Retries: 2, Delay: "0.001", RetryOn: "timeout"}
+	retry, err := rj.retryPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cj := &cronJob{RawJob: rj, stop: make(chan struct{}), retry: retry}
+
+	fails := 1
+	cj.run(func(jobRun) error {
+		if fails > 0 {
+			fails--
+			return errors.New("read timeout")
+		}
+		return nil
+	}, time.Now())
+	if st := cj.status(); st.Successes != 1 || st.Recent[0].Attempts != 2 {
+		t.Fatalf("retried: %+v", st)
+	}
+
+	cj.run(func(jobRun) error { return errors.New("timeout") }, time.Now())
+	if st := cj.status(); st.Failures != 1 || st.Recent[1].Attempts != 3 {
+		t.Fatalf("exhausted: %+v", st)
+	}
+
+	cj.run(func(jobRun) error { return errors.New("syntax error") }, time.Now())
+	if st := cj.status(); st.Recent[2].Attempts != 1 {
+		t.Fatalf("retryOn: %+v", st)
+	}
+
+	exp := retryPolicy{exponential: true, delay: time.Second}
+	if d := exp.backoff(3); d < 2*time.Second || d >= 4*time.Second {
+		t.Fatalf("backoff(3)=%v", d)
+	}
+	if d := exp.backoff(30); d > maxRetryDelay {
+		t.Fatalf("backoff(30)=%v", d)
+	}
+	for _, bad := range []RawJob{{Retries: -1}, {Backoff: "linear"}, {Delay: "soon"}, {RetryOn: "("}} {
+		bad.RawSpec = "@hourly"
+		if bad.Valid() == nil {
+			t.Fatalf("expect invalid: %+v", bad)
+		}
+	}
+}
@@ -4,46 +4,147 @@
 package base
 
 import (
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"os"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/robfig/cron"
 	"github.com/yuin/gopher-lua"
 )
 
+// RawJob is a job of the crontab table:
+//
+//	{sec='0', min='0', hour='9', dow='MON-FRI', lvm=1, doFile='lua/report.lua'}
+//	{id='ping', spec='@every 90s', overlap='queue', doFile='lua/ping.lua'}
+//	{spec='0 0 0 1 * ?', tz='Asia/Shanghai', doFile='lua/monthly.lua'}
+//	{spec='@hourly', retries=3, backoff='exponential', delay='10s', retryOn='timeout', doFile='lua/sync.lua'}
+//	{id='acme', spec='@daily', args={'acme', 7}, env={TENANT='acme'}, doFile='lua/report.lua'}
+//	{id='billing', spec='0 0 2 * * ?', singleton=true, doFile='lua/billing.lua'}
+//
+// overlap decides what happens when the job is due while the previous run is still
+// running: allow them to run at once (the default), skip it, or queue it to run after;
+// up to 10 runs are queued, the others are skipped. The scripts of a lvm run one at a
+// time, so the runs of a job with lvm>0 are queued unless overlap is skip, and the jobs
+// with the same lvm>0 wait for each other.
+// A failed run is retried up to retries times when its error matches the regexp retryOn
+// (any error if it isn't set), waiting delay (1s by default, "30s" or seconds) between
+// the attempts, or delay*2^n with jitter if backoff='exponential'.
+// id is optional, it keeps the job across the reloads when the other fields change.
+// spec is a full cron expression with seconds or a descriptor (@hourly, @every 1h...),
+// the other time fields are ignored if it is set. tz is the time zone of the job, local
+// by default.
+// args are passed to the script as ..., env is seen by os.getenv before the environment
+// of the process, and the run is the global job: job.id, job.scheduled (the time the run
+// was due), job.attempt (1 + the retries), job.args and job.env. In a shared lvm the
+// global is replaced by every run.
+// A singleton job runs on one replica only, the one which holds the lease of the job
+// from Crontab.Locker when it's due; the lease is renewed by every run, so a replica
+// keeps the job while its ticks are less than Crontab.LockTTL apart. The ticks of @every depend on the start time of the replica, so
+// a singleton job should use a cron expression.
 type RawJob struct {
-	Sec, Min, Hour string
-	LvmId          int
-	FilePath       string
+	Sec, Min, Hour  string
+	Dom, Month, Dow string
+	RawSpec         string
+	TZ              string
+	Id              string
+	Overlap         string
+	Retries         int
+	Backoff         string
+	Delay           string
+	RetryOn         string
+	Args            string // json array, like RawJob it is comparable
+	Env             string // json object of strings
+	Singleton       bool
+	LvmId           int
+	FilePath        string
 }
 
 func (rj RawJob) Spec() string {
-	return fmt.Sprintf("%s %s %s * * ?", rj.Sec, rj.Min, rj.Hour)
+	if len(rj.RawSpec) > 0 {
+		return rj.RawSpec
+	}
+	return fmt.Sprintf("%s %s %s %s %s %s", orDefault(rj.Sec, "0"), orDefault(rj.Min, "*"), orDefault(rj.Hour, "*"),
+		orDefault(rj.Dom, "*"), orDefault(rj.Month, "*"), orDefault(rj.Dow, "?"))
+}
+
+// ID is the id of the job, a hash of the job if it isn't set in the crontab
+func (rj RawJob) ID() string {
+	if len(rj.Id) > 0 {
+		return rj.Id
+	}
+	h := fnv.New64a()
+	fmt.Fprint(h, rj)
+	return fmt.Sprintf("job-%016x", h.Sum64())
+}
+
+// Schedule parses the Spec in the time zone TZ
+func (rj RawJob) Schedule() (cron.Schedule, error) {
+	sched, err := cron.Parse(rj.Spec())
+	if err != nil {
+		return nil, err
+	}
+	if len(rj.TZ) == 0 {
+		return sched, nil
+	}
+	loc, err := time.LoadLocation(rj.TZ)
+	if err != nil {
+		return nil, err
+	}
+	return tzSchedule{sched, loc}, nil
+}
+
+// the schedules of robfig/cron are calculated in the location of the time passed to Next
+type tzSchedule struct {
+	cron.Schedule
+	loc *time.Location
 }
 
+func (s tzSchedule) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t.In(s.loc))
+}
+
+func orDefault(s, def string) string {
+	if len(s) == 0 {
+		return def
+	}
+	return s
+}
+
+// Run runs the script of the job once, as the first attempt due now
+func (rj RawJob) Run(plf PreloadFunc) error {
+	return rj.runScript(rj.newRun(time.Now(), 1), plf, nil)
+}
+
+// Cmd is Run for the cron runners which recover the panics
 func (rj RawJob) Cmd(plf PreloadFunc) func() {
-	if rj.LvmId == 0 {
-		return func() {
-			if err := DoFileOnce(rj.FilePath, plf); err != nil {
-				panic(err) //will be catched by recover
-			}
-		}
-	} else {
-		return func() {
-			if err := DoFileInLuaVM(rj.LvmId, rj.FilePath, plf); err != nil {
-				panic(err) //will be catched by recover
-			}
+	return func() {
+		if err := rj.Run(plf); err != nil {
+			panic(err) //will be catched by recover
 		}
 	}
 }
 
 func (rj RawJob) Valid() error {
-	var err error
 	if rj.LvmId < 0 || rj.LvmId > MAX_LVM_NUM {
-		err = fmt.Errorf("Invalid lvm num.")
-	} else {
-		_, err = cron.Parse(rj.Spec())
+		return fmt.Errorf("Invalid lvm num.")
+	}
+	if !validOverlap(rj.Overlap) {
+		return fmt.Errorf("Invalid overlap %s, expect skip, queue or allow.", rj.Overlap)
 	}
+	if _, err := rj.retryPolicy(); err != nil {
+		return err
+	}
+	if _, err := rj.jobArgs(); err != nil {
+		return err
+	}
+	if _, err := rj.jobEnv(); err != nil {
+		return err
+	}
+	_, err := rj.Schedule()
 	return err
 }
 
@@ -52,82 +153,291 @@ type RawJobs []RawJob
 func (s RawJobs) Len() int      { return len(s) }
 func (s RawJobs) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s RawJobs) Less(i, j int) bool {
-	return s[i].Spec() < s[j].Spec()
-}
-
-func loadCrontab(cronFile string) (RawJobs, error) {
-	jobs := make([]RawJob, 0, 0)
-	err := DoFileInLuaVM(1, cronFile, func(L *lua.LState) error {
-		// func to get jobs from lua
-		L.Register("setJobs", func(L2 *lua.LState) int {
-			all := L2.CheckTable(1)
-			for i := 0; i < all.MaxN(); i++ {
-				tv := all.RawGetInt(i + 1).(*lua.LTable)
-				job := RawJob{
-					tv.RawGetString("sec").String(),
-					tv.RawGetString("min").String(),
-					tv.RawGetString("hour").String(),
-					int(lua.LVAsNumber(tv.RawGetString("lvm"))),
-					tv.RawGetString("doFile").String(),
-				}
-				if err := job.Valid(); err != nil {
-					L2.RaiseError("Invalid job(idx %d: %v):%s", i+1, job, err)
-					return 0
-				}
-				jobs = append(jobs, job)
-			}
-			return 0
-		}) // Register func end
-		return nil
-	})
-	return jobs, err
+	if s[i].Spec() != s[j].Spec() {
+		return s[i].Spec() < s[j].Spec()
+	}
+	return fmt.Sprint(s[i]) < fmt.Sprint(s[j])
 }
 
+// Crontab runs the jobs of a crontab file, every job has its own timer so Load only
+// touches the changed jobs.
 type Crontab struct {
-	cur  RawJobs
-	task *cron.Cron
+	// the run history of the jobs is saved to HistoryFile after every run and loaded
+	// by the first Load, "" to keep it in memory only
+	HistoryFile string
+
+	// the options of Watch
+	Debounce          time.Duration                      // wait for the changes to settle, 500ms by default
+	PrecompileScripts bool                               // precompile the doFile scripts for the jobs, recompile them on change
+	OnReload          func(report LoadReport, err error) // called after every reload by Watch, nil to log only
+
+	// the options of the singleton jobs, RunNow doesn't take the lease
+	Locker  Locker        // required by the singleton jobs
+	Owner   string        // the owner of the leases, hostname:pid by default
+	LockTTL time.Duration // the lease of a job is kept for it after a tick, 1 minute by default
+
+	mu       sync.Mutex
+	jobs     map[string]*cronJob   // id -->
+	paused   map[string]bool       // id --> kept across the reloads
+	pauseAll bool                  // the added jobs are paused too
+	fileMu   sync.Mutex
+
+	protoMu sync.RWMutex
+	protos  map[string]*lua.FunctionProto // doFile --> precompiled by Watch
+}
+
+// LoadReport is the ids of the jobs changed by Crontab.Load
+type LoadReport struct {
+	Added, Removed, Updated []string
+}
+
+func (r LoadReport) Changed() bool {
+	return len(r.Added)+len(r.Removed)+len(r.Updated) > 0
 }
 
-//return need re-build-jobs
-func (c *Crontab) needBuild(ld RawJobs) bool {
+// Load reloads the crontab file: the unchanged jobs keep running with their
+// next-run time, the removed jobs are stopped and the added/updated jobs are
+// (re)scheduled. A job without id is identified by its content, so changing it
+// is reported as removed and added.
+func (c *Crontab) Load(cronFile string, plf PreloadFunc) (LoadReport, error) {
+	var report LoadReport
+	ld, err := loadCrontab(cronFile)
+	if err != nil {
+		return report, err
+	}
 	sort.Sort(ld)
+	next := make(map[string]RawJob, len(ld))
+	for _, job := range ld {
+		if _, ok := next[job.ID()]; ok {
+			return report, fmt.Errorf("Duplicate job id %s.", job.ID())
+		}
+		next[job.ID()] = job
+		if job.Singleton && c.Locker == nil {
+			return report, fmt.Errorf("Task %s is singleton, but Crontab.Locker isn't set.", job.ID())
+		}
+	}
 
-	if len(c.cur) != len(ld) {
-		c.cur = ld
-		return true
-	} else {
-		for i := range ld {
-			if ld[i] != c.cur[i] {
-				c.cur = ld
-				return true
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logs := make(map[string]*runLog)
+	if c.jobs == nil {
+		c.jobs = make(map[string]*cronJob)
+		for id, h := range c.loadHistory() {
+			logs[id] = &runLog{jobHistory: h}
+		}
+		c.paused = make(map[string]bool)
+	}
+	for id, cj := range c.jobs {
+		if _, ok := next[id]; !ok {
+			c.unschedule(id, cj)
+			report.Removed = append(report.Removed, id)
+		}
+	}
+	for _, job := range ld {
+		id := job.ID()
+		if cj, ok := c.jobs[id]; ok {
+			if cj.RawJob == job {
+				continue
 			}
+			c.unschedule(id, cj)
+			logs[id] = cj.history()
+			report.Updated = append(report.Updated, id)
+		} else {
+			report.Added = append(report.Added, id)
 		}
+		c.schedule(job, plf, logs[id])
+	}
+	sort.Strings(report.Removed)
+	if report.Changed() {
+		logger.Info("reload crontab for %d tasks, added %v, removed %v, updated %v",
+			len(c.jobs), report.Added, report.Removed, report.Updated)
 	}
-	return false
+	return report, nil
 }
 
-func (c *Crontab) Load(cronFile string, plf PreloadFunc) error {
-	ld, err := loadCrontab(cronFile)
+// Stop stops all the jobs, the running ones are not interrupted
+func (c *Crontab) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, cj := range c.jobs {
+		c.unschedule(id, cj)
+	}
+}
+
+// log is the history of the replaced job or loaded from HistoryFile, nil for a new one
+func (c *Crontab) schedule(job RawJob, plf PreloadFunc, log *runLog) {
+	sched, _ := job.Schedule()    //checked by Valid
+	retry, _ := job.retryPolicy() //checked by Valid
+	cj := &cronJob{
+		RawJob: job,
+		cmd:    func(run jobRun) error { return job.runScript(run, plf, c.cachedProto(job.FilePath)) },
+		stop:   make(chan struct{}),
+		done:   c.saveHistory,
+		retry:  retry,
+		paused: c.pauseAll || c.paused[job.ID()],
+		log:    log,
+	}
+	if job.Singleton {
+		cj.lease = c.leaseFunc(job.ID())
+	}
+	if cj.paused {
+		c.paused[job.ID()] = true
+	}
+	c.jobs[job.ID()] = cj
+	go cj.loop(sched)
+	logger.Info("schedule task %s: %v", job.ID(), job)
+}
+
+// the lease of the job, the run is skipped if it fails
+func (c *Crontab) leaseFunc(id string) func(scheduled time.Time) bool {
+	locker, owner, ttl := c.Locker, c.Owner, c.LockTTL
+	if len(owner) == 0 {
+		owner = defaultOwner()
+	}
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	return func(scheduled time.Time) bool {
+		ok, err := locker.TryLock(id, owner, ttl)
+		if err != nil {
+			logger.Error("lock task %s: %v", id, err)
+		}
+		return ok && err == nil
+	}
+}
+
+func (c *Crontab) unschedule(id string, cj *cronJob) {
+	close(cj.stop)
+	delete(c.jobs, id)
+	c.dropProto(cj.FilePath)
+	logger.Info("unschedule task %s: %v", id, cj.RawJob)
+}
+
+// Status returns the status of every job, ordered by id
+func (c *Crontab) Status() []JobStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	all := make([]JobStatus, 0, len(c.jobs))
+	for _, cj := range c.jobs {
+		all = append(all, cj.status())
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+func (c *Crontab) loadHistory() map[string]jobHistory {
+	history := make(map[string]jobHistory)
+	if len(c.HistoryFile) == 0 {
+		return history
+	}
+	bt, err := os.ReadFile(c.HistoryFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("load the history of crontab: %v", err)
+		}
+		return history
+	}
+	if err := json.Unmarshal(bt, &history); err != nil {
+		logger.Error("load the history of crontab: %v", err)
+	}
+	return history
+}
+
+// write to a temporary file and rename it, a crash never leaves half a file
+func (c *Crontab) saveHistory() {
+	if len(c.HistoryFile) == 0 {
+		return
+	}
+	c.mu.Lock()
+	history := make(map[string]jobHistory, len(c.jobs))
+	for id, cj := range c.jobs {
+		history[id] = cj.snapshot()
+	}
+	c.mu.Unlock()
+
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+	bt, err := json.Marshal(history)
+	if err == nil {
+		tmp := c.HistoryFile + ".tmp"
+		if err = os.WriteFile(tmp, bt, 0644); err == nil {
+			err = os.Rename(tmp, c.HistoryFile)
+		}
+	}
+	if err != nil {
+		logger.Error("save the history of crontab: %v", err)
+	}
+}
+
+// RunNow starts the job at once, even if it's paused. The overlap policy is applied,
+// an error is returned if the run is skipped.
+func (c *Crontab) RunNow(jobID string) error {
+	cj, err := c.job(jobID)
 	if err != nil {
 		return err
 	}
+	return cj.start(cj.cmd, time.Now(), true)
+}
+
+// Pause ignores the scheduled runs of the job until Resume, the running one isn't stopped
+func (c *Crontab) Pause(jobID string) error {
+	return c.setPaused(jobID, true)
+}
+
+func (c *Crontab) Resume(jobID string) error {
+	return c.setPaused(jobID, false)
+}
+
+// PauseAll pauses all the jobs, including the ones added by the later reloads
+func (c *Crontab) PauseAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pauseAll = true
+	for id := range c.jobs {
+		c.setPausedLocked(id, true)
+	}
+}
 
-	if !c.needBuild(ld) {
-		return nil
+func (c *Crontab) ResumeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pauseAll = false
+	for id := range c.jobs {
+		c.setPausedLocked(id, false)
 	}
+	c.paused = make(map[string]bool)
+}
 
-	if c.task != nil {
-		c.task.Stop()
-		c.task = nil
+func (c *Crontab) job(jobID string) (*cronJob, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cj, ok := c.jobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("Unknown task %s.", jobID)
 	}
-	c.task = cron.New()
-	c.task.ErrorLog = logger
-	c.task.Start()
+	return cj, nil
+}
 
-	for _, job := range c.cur {
-		c.task.AddFunc(job.Spec(), job.Cmd(plf))
-		logger.Info("reload crontab for task: %v", job)
+func (c *Crontab) setPaused(jobID string, paused bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.jobs[jobID]; !ok {
+		return fmt.Errorf("Unknown task %s.", jobID)
 	}
-	logger.Info("reload crontab for %d tasks", len(c.cur))
+	c.setPausedLocked(jobID, paused)
 	return nil
 }
+
+// c.mu is locked
+func (c *Crontab) setPausedLocked(jobID string, paused bool) {
+	if paused {
+		c.paused[jobID] = true
+	} else {
+		delete(c.paused, jobID)
+	}
+	cj := c.jobs[jobID]
+	cj.mu.Lock()
+	cj.paused = paused
+	cj.mu.Unlock()
+	logger.Info("task %s paused: %v", jobID, paused)
+}
@@ -0,0 +1,493 @@
+//Lua.go
+
+//Load the crontab from lua, yaml, json or toml, selected by the file extension
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+	"gopkg.in/yaml.v3"
+)
+
+// CrontabLoader reads the jobs from a crontab file, the jobs are validated by Load.
+type CrontabLoader func(cronFile string) (RawJobs, error)
+
+var (
+	crontabLoaders = map[string]CrontabLoader{
+		".lua":  loadLuaCrontab,
+		".yaml": loadYAMLCrontab,
+		".yml":  loadYAMLCrontab,
+		".json": loadJSONCrontab,
+		".toml": loadTOMLCrontab,
+	}
+	loaderLock sync.RWMutex
+)
+
+// RegisterCrontabLoader sets the loader of the files with the extension ext, like ".ini".
+func RegisterCrontabLoader(ext string, loader CrontabLoader) {
+	loaderLock.Lock()
+	defer loaderLock.Unlock()
+	// the built-in loaders validate the jobs in parseJobs, with their lines
+	crontabLoaders[strings.ToLower(ext)] = func(cronFile string) (RawJobs, error) {
+		jobs, err := loader(cronFile)
+		if err != nil {
+			return nil, err
+		}
+		for i, job := range jobs {
+			if err := job.Valid(); err != nil {
+				return nil, fmt.Errorf("%s: job %d: %v", cronFile, i+1, err)
+			}
+		}
+		return jobs, nil
+	}
+}
+
+func loadCrontab(cronFile string) (RawJobs, error) {
+	ext := strings.ToLower(filepath.Ext(cronFile))
+	loaderLock.RLock()
+	loader, ok := crontabLoaders[ext]
+	loaderLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unsupported crontab file %s.", cronFile)
+	}
+	return loader(cronFile)
+}
+
+//==================================
+// the schema shared by all the formats, the keys of the lua table
+type jobEntry struct {
+	fields map[string]interface{}
+	line   int // 0 if unknown
+}
+
+var jobStringKeys = map[string]func(*RawJob) *string{
+	"sec":     func(rj *RawJob) *string { return &rj.Sec },
+	"min":     func(rj *RawJob) *string { return &rj.Min },
+	"hour":    func(rj *RawJob) *string { return &rj.Hour },
+	"dom":     func(rj *RawJob) *string { return &rj.Dom },
+	"month":   func(rj *RawJob) *string { return &rj.Month },
+	"dow":     func(rj *RawJob) *string { return &rj.Dow },
+	"spec":    func(rj *RawJob) *string { return &rj.RawSpec },
+	"tz":      func(rj *RawJob) *string { return &rj.TZ },
+	"id":      func(rj *RawJob) *string { return &rj.Id },
+	"overlap": func(rj *RawJob) *string { return &rj.Overlap },
+	"backoff": func(rj *RawJob) *string { return &rj.Backoff },
+	"delay":   func(rj *RawJob) *string { return &rj.Delay },
+	"retryOn": func(rj *RawJob) *string { return &rj.RetryOn },
+	"doFile":  func(rj *RawJob) *string { return &rj.FilePath },
+}
+
+var jobIntKeys = map[string]func(*RawJob) *int{
+	"lvm":     func(rj *RawJob) *int { return &rj.LvmId },
+	"retries": func(rj *RawJob) *int { return &rj.Retries },
+}
+
+var jobBoolKeys = map[string]func(*RawJob) *bool{
+	"singleton": func(rj *RawJob) *bool { return &rj.Singleton },
+}
+
+var jobJSONKeys = map[string]struct {
+	field func(*RawJob) *string
+	parse func(v interface{}) (string, error)
+}{
+	"args": {func(rj *RawJob) *string { return &rj.Args }, jobArgs},
+	"env":  {func(rj *RawJob) *string { return &rj.Env }, jobEnv},
+}
+
+// entries --> RawJobs, the errors are "file:line: job N: ..."
+func parseJobs(cronFile string, entries []jobEntry) (RawJobs, error) {
+	jobs := make(RawJobs, 0, len(entries))
+	for i, e := range entries {
+		job, err := parseJob(e.fields)
+		if err == nil {
+			err = job.Valid()
+		}
+		if err != nil {
+			if e.line > 0 {
+				return nil, fmt.Errorf("%s:%d: job %d: %v", cronFile, e.line, i+1, err)
+			}
+			return nil, fmt.Errorf("%s: job %d: %v", cronFile, i+1, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func parseJob(fields map[string]interface{}) (RawJob, error) {
+	var job RawJob
+	for k, v := range fields {
+		if f, ok := jobStringKeys[k]; ok {
+			s, err := jobString(k, v)
+			if err != nil {
+				return job, err
+			}
+			*f(&job) = s
+		} else if f, ok := jobIntKeys[k]; ok {
+			n, err := jobInt(k, v)
+			if err != nil {
+				return job, err
+			}
+			*f(&job) = n
+		} else if f, ok := jobBoolKeys[k]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return job, fmt.Errorf("%s: expect boolean, got %v.", k, v)
+			}
+			*f(&job) = b
+		} else if f, ok := jobJSONKeys[k]; ok {
+			s, err := f.parse(v)
+			if err != nil {
+				return job, err
+			}
+			*f.field(&job) = s
+		} else {
+			return job, fmt.Errorf("unknown key %s.", k)
+		}
+	}
+	if len(job.FilePath) == 0 {
+		return job, fmt.Errorf("doFile is required.")
+	}
+	return job, nil
+}
+
+// numbers are allowed for the time fields, sec=0
+func jobString(key string, v interface{}) (string, error) {
+	switch sv := v.(type) {
+	case string:
+		return sv, nil
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(sv), nil
+	}
+	return "", fmt.Errorf("%s: expect string, got %T.", key, v)
+}
+
+func jobInt(key string, v interface{}) (int, error) {
+	switch nv := v.(type) {
+	case int:
+		return nv, nil
+	case int64:
+		if nv >= math.MinInt && nv <= math.MaxInt {
+			return int(nv), nil
+		}
+	case uint64:
+		if nv <= math.MaxInt {
+			return int(nv), nil
+		}
+	case float64:
+		if nv != math.Trunc(nv) {
+			return 0, fmt.Errorf("%s: expect integer, got %v.", key, v)
+		}
+		// float64(math.MaxInt) is rounded up to 2^63 on the 64-bit platforms
+		if nv >= math.MinInt && nv < -float64(math.MinInt) {
+			return int(nv), nil
+		}
+	default:
+		return 0, fmt.Errorf("%s: expect integer, got %v.", key, v)
+	}
+	return 0, fmt.Errorf("%s: %v is out of the int range.", key, v)
+}
+
+//==================================
+// setJobs({...}) in a private LState. The line of a job is the line of its table when
+// the tables with doFile in the source match the jobs one to one, in order and by the
+// literal doFile, otherwise it's the line of setJobs, e.g. for the jobs made in a loop.
+func loadLuaCrontab(cronFile string) (RawJobs, error) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+	var (
+		jobs RawJobs
+		err  error
+	)
+	// the errors are kept on the golang side, they have the file and the line already
+	L.Register("setJobs", func(L2 *lua.LState) int {
+		all := L2.CheckTable(1)
+		line := luaLine(L2.Where(1))
+		entries := make([]jobEntry, 0, all.MaxN())
+		for i := 0; i < all.MaxN() && err == nil; i++ {
+			tv, ok := all.RawGetInt(i + 1).(*lua.LTable)
+			if !ok {
+				err = fmt.Errorf("%s:%d: job %d: expect table, got %s.", cronFile, line, i+1, all.RawGetInt(i+1).Type())
+				break
+			}
+			fields := make(map[string]interface{})
+			tv.ForEach(func(k, v lua.LValue) {
+				switch lv := v.(type) {
+				case lua.LNumber:
+					fields[k.String()] = float64(lv)
+				case lua.LString:
+					fields[k.String()] = string(lv)
+				case lua.LBool:
+					fields[k.String()] = bool(lv)
+				case *lua.LTable: //args and env
+					jv, jerr := jsonValue(L2, lv)
+					if jerr != nil && err == nil {
+						err = fmt.Errorf("%s:%d: job %d: %s: %v", cronFile, line, i+1, k, jerr)
+					}
+					fields[k.String()] = jv
+				default:
+					fields[k.String()] = v //rejected by parseJob
+				}
+			})
+			entries = append(entries, jobEntry{fields, line})
+		}
+		if err == nil {
+			jobs, err = parseJobs(cronFile, luaJobLines(cronFile, entries))
+		}
+		return 0
+	})
+	if derr := L.DoFile(cronFile); derr != nil && err == nil {
+		return nil, derr
+	}
+	return jobs, err
+}
+
+// the lines of the job tables, see loadLuaCrontab
+func luaJobLines(cronFile string, entries []jobEntry) []jobEntry {
+	f, err := os.Open(cronFile)
+	if err != nil {
+		return entries
+	}
+	defer f.Close()
+	chunk, err := parse.Parse(f, cronFile)
+	if err != nil {
+		return entries
+	}
+	tables := luaJobTables(chunk)
+	if len(tables) != len(entries) {
+		return entries
+	}
+	for i, t := range tables {
+		if doFile, _ := entries[i].fields["doFile"].(string); len(t.doFile) > 0 && t.doFile != doFile {
+			return entries
+		}
+	}
+	for i, t := range tables {
+		entries[i].line = t.line
+	}
+	return entries
+}
+
+// a table constructor with the key doFile, doFile is "" if it isn't a literal
+type luaJobTable struct {
+	line   int
+	doFile string
+}
+
+// the job tables in the order of the source, the ast is walked by reflect
+func luaJobTables(chunk []ast.Stmt) []luaJobTable {
+	var tables []luaJobTable
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Ptr:
+			if v.IsNil() {
+				return
+			}
+			if te, ok := v.Interface().(*ast.TableExpr); ok {
+				for _, f := range te.Fields {
+					if k, ok := f.Key.(*ast.StringExpr); ok && k.Value == "doFile" {
+						t := luaJobTable{line: te.Line()}
+						if s, ok := f.Value.(*ast.StringExpr); ok {
+							t.doFile = s.Value
+						}
+						tables = append(tables, t)
+					}
+				}
+			}
+			walk(v.Elem())
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).IsExported() {
+					walk(v.Field(i))
+				}
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(chunk))
+	sort.SliceStable(tables, func(i, j int) bool { return tables[i].line < tables[j].line })
+	return tables
+}
+
+var luaWhere = regexp.MustCompile(`:(\d+):\s*$`)
+
+// "crontab.lua:3:" --> 3
+func luaLine(where string) int {
+	var line int
+	if m := luaWhere.FindStringSubmatch(where); m != nil {
+		fmt.Sscan(m[1], &line)
+	}
+	return line
+}
+
+// jobs:
+//   - {id: report, hour: 9, doFile: lua/report.lua}
+func loadYAMLCrontab(cronFile string) (RawJobs, error) {
+	data, err := os.ReadFile(cronFile)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %v", cronFile, err)
+	}
+	if len(root.Content) == 0 {
+		return RawJobs{}, nil
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expect a mapping with the key jobs.", cronFile, doc.Line)
+	}
+	var entries []jobEntry
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+		if key.Value != "jobs" {
+			return nil, fmt.Errorf("%s:%d: unknown key %s.", cronFile, key.Line, key.Value)
+		}
+		if value.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("%s:%d: jobs: expect a list.", cronFile, value.Line)
+		}
+		for _, item := range value.Content {
+			fields := make(map[string]interface{})
+			if err := item.Decode(&fields); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", cronFile, item.Line, err)
+			}
+			entries = append(entries, jobEntry{fields, item.Line})
+		}
+	}
+	return parseJobs(cronFile, entries)
+}
+
+// {"jobs": [{"id": "report", "hour": "9", "doFile": "lua/report.lua"}]}
+func loadJSONCrontab(cronFile string) (RawJobs, error) {
+	data, err := os.ReadFile(cronFile)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(offset int64, format string, v ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s", cronFile, lineAt(data, offset), fmt.Sprintf(format, v...))
+	}
+	jsonErr := func(err error) error {
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			return fail(e.Offset, "%v", e)
+		case *json.UnmarshalTypeError:
+			return fail(e.Offset, "%v", e)
+		}
+		return fmt.Errorf("%s: %v", cronFile, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tk, err := dec.Token(); err != nil {
+		return nil, jsonErr(err)
+	} else if tk != json.Delim('{') {
+		return nil, fail(dec.InputOffset(), "expect an object with the key jobs.")
+	}
+	var entries []jobEntry
+	for dec.More() {
+		tk, err := dec.Token()
+		if err != nil {
+			return nil, jsonErr(err)
+		}
+		if tk != "jobs" {
+			return nil, fail(dec.InputOffset(), "unknown key %v.", tk)
+		}
+		if tk, err := dec.Token(); err != nil {
+			return nil, jsonErr(err)
+		} else if tk != json.Delim('[') {
+			return nil, fail(dec.InputOffset(), "jobs: expect an array.")
+		}
+		for dec.More() {
+			start := nextValue(data, dec.InputOffset())
+			fields := make(map[string]interface{})
+			if err := dec.Decode(&fields); err != nil {
+				return nil, jsonErr(err)
+			}
+			entries = append(entries, jobEntry{fields, lineAt(data, start)})
+		}
+		if _, err := dec.Token(); err != nil { // ]
+			return nil, jsonErr(err)
+		}
+	}
+	return parseJobs(cronFile, entries)
+}
+
+// skip the spaces and the comma before a value
+func nextValue(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// [[jobs]]
+// id = "report"
+// doFile = "lua/report.lua"
+func loadTOMLCrontab(cronFile string) (RawJobs, error) {
+	data, err := os.ReadFile(cronFile)
+	if err != nil {
+		return nil, err
+	}
+	var tab struct {
+		Jobs []map[string]interface{} `toml:"jobs"`
+	}
+	md, err := toml.Decode(string(data), &tab)
+	if err != nil {
+		if pe, ok := err.(toml.ParseError); ok {
+			return nil, fmt.Errorf("%s:%d: %s", cronFile, pe.Position.Line, pe.Message)
+		}
+		return nil, fmt.Errorf("%s: %v", cronFile, err)
+	}
+	// the keys in the jobs are checked by parseJob, and the nested ones are reported too
+	for _, key := range md.Undecoded() {
+		if len(key) == 1 {
+			return nil, fmt.Errorf("%s: unknown key %s.", cronFile, key)
+		}
+	}
+	// the lines of the [[jobs]] headers. The inline tables of jobs = [{...}] have no
+	// line in the decoded value, their errors are reported without one
+	var lines []int
+	for i, l := range strings.Split(string(data), "\n") {
+		if tomlJobsHeader.MatchString(l) {
+			lines = append(lines, i+1)
+		}
+	}
+	entries := make([]jobEntry, len(tab.Jobs))
+	for i, fields := range tab.Jobs {
+		entries[i].fields = fields
+		if len(lines) == len(tab.Jobs) {
+			entries[i].line = lines[i]
+		}
+	}
+	return parseJobs(cronFile, entries)
+}
+
+var tomlJobsHeader = regexp.MustCompile(`^\s*\[\[\s*jobs\s*\]\]`)
@@ -0,0 +1,70 @@
+//
+
+package base
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCrontabLoaders(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	want := RawJob{Id: "report", Hour: "9", Dow: "MON-FRI", Retries: 2, LvmId: 1, FilePath: "report.lua",
+		Args: `["acme",7]`, Env: `{"TENANT":"acme"}`}
+
+	for _, tc := range []struct{ name, content string }{
+		{"crontab.lua", `setJobs({{id='report', hour=9, dow='MON-FRI', retries=2, lvm=1, doFile='report.lua', args={'acme', 7}, env={TENANT='acme'}}})`},
+		{"crontab.yaml", "jobs:\n  - id: report\n    hour: 9\n    dow: MON-FRI\n    retries: 2\n    lvm: 1\n    doFile: report.lua\n    args: [acme, 7]\n    env: {TENANT: acme}\n"},
+		{"crontab.json", `{"jobs": [{"id": "report", "hour": "9", "dow": "MON-FRI", "retries": 2, "lvm": 1, "doFile": "report.lua", "args": ["acme", 7], "env": {"TENANT": "acme"}}]}`},
+		{"crontab.toml", "[[jobs]]\nid = \"report\"\nhour = 9\ndow = \"MON-FRI\"\nretries = 2\nlvm = 1\ndoFile = \"report.lua\"\nargs = [\"acme\", 7]\nenv = {TENANT = \"acme\"}\n"},
+	} {
+		jobs, err := loadCrontab(write(tc.name, tc.content))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(jobs) != 1 || jobs[0] != want {
+			t.Fatalf("%s: %+v", tc.name, jobs)
+		}
+	}
+
+	// the jobs of a registered loader are validated after it
+	RegisterCrontabLoader(".jobs", func(string) (RawJobs, error) { return RawJobs{{Hour: "9", LvmId: 99}}, nil })
+	defer func() {
+		loaderLock.Lock()
+		delete(crontabLoaders, ".jobs")
+		loaderLock.Unlock()
+	}()
+
+	for _, tc := range []struct{ name, content, err string }{
+		{"bad.yaml", "jobs:\n  - doFile: a.lua\n  - doFile: b.lua\n    sce: 1\n", ":3: job 2: unknown key sce."},
+		{"bad.yaml", "jobs:\n  - doFile: a.lua\n    hour: [\n", "bad.yaml: yaml: line"},
+		{"bad.json", "{\"jobs\": [\n  {\"doFile\": \"a.lua\"},\n  {\"doFile\": \"b.lua\", \"lvm\": 99}\n]}", ":3: job 2: Invalid lvm num."},
+		{"bad.json", "{\"jobs\": [\n  {\"doFile\": \"a.lua\",}\n]}", ":2: "},
+		{"bad.toml", "[[jobs]]\ndoFile = \"a.lua\"\n\n[[jobs]]\nhour = 9\n", ":4: job 2: doFile is required."},
+		{"bad.toml", "[[jobs]]\ndoFile = \n", ":2: "},
+		{"bad.lua", "\nsetJobs({{doFile='a.lua', dow={1}}})", ":2: job 1: dow: expect string"},
+		{"bad.lua", "setJobs({\n  {doFile='a.lua'},\n  {doFile='b.lua', lvm=99},\n})", "bad.lua:3: job 2: Invalid lvm num."},
+		{"bad.lua", "local jobs = {}\nfor _, f in ipairs({'a.lua', 'b.lua'}) do\n  table.insert(jobs, {doFile=f, sce=1})\nend\nsetJobs(jobs)", "bad.lua:5: job 1: unknown key sce."},
+		{"bad.toml", "jobs = [{doFile = \"a.lua\"}, {hour = 9}]\n", "bad.toml: job 2: doFile is required."},
+		{"bad.lua", "setJobs({\n  {doFile='a.lua', retries=1.5},\n})", "bad.lua:2: job 1: retries: expect integer, got 1.5."},
+		{"bad.lua", "setJobs({\n  {doFile='a.lua', retries=2^63},\n})", "bad.lua:2: job 1: retries: 9.223372036854776e+18 is out of the int range."},
+		{"bad.yaml", "jobs:\n  - doFile: a.lua\n    retries: 18446744073709551615\n", ":2: job 1: retries: 18446744073709551615 is out of the int range."},
+		{"bad.json", "{\"jobs\": [\n  {\"doFile\": \"a.lua\", \"lvm\": 1e300}\n]}", ":2: job 1: lvm: 1e+300 is out of the int range."},
+		{"bad.jobs", "", "bad.jobs: job 1: Invalid lvm num."},
+		{"crontab.ini", "", "Unsupported crontab file"},
+	} {
+		_, err := loadCrontab(write(tc.name, tc.content))
+		if err == nil || !strings.Contains(err.Error(), tc.err) || strings.Count(err.Error(), tc.name) != 1 {
+			t.Fatalf("%s: expect %q, got %v", tc.content, tc.err, err)
+		}
+	}
+}
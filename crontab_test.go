@@ -4,6 +4,9 @@ package base
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -33,7 +36,7 @@ func TestCrontab(t *testing.T) {
 		case <-stop:
 			return
 		case <-tkr.C:
-			if err := ct.Load("lua/crontab.lua", func(L *lua.LState) error {
+			if _, err := ct.Load("lua/crontab.lua", func(L *lua.LState) error {
 				//preload the api
 				L.Register("sayHello", func(L2 *lua.LState) int {
 					p := L2.CheckString(1)
@@ -48,3 +51,180 @@ func TestCrontab(t *testing.T) {
 	}
 
 }
+
+func TestRawJobSchedule(t *testing.T) {
+	weekdays := RawJob{Min: "0", Hour: "9", Dow: "MON-FRI"}
+	if weekdays.Spec() != "0 0 9 * * MON-FRI" {
+		t.Fatalf("Spec: %s", weekdays.Spec())
+	}
+	sched, err := weekdays.Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sat := time.Date(2020, 1, 4, 10, 0, 0, 0, time.Local) // Saturday
+	if next := sched.Next(sat); next.Weekday() != time.Monday || next.Hour() != 9 {
+		t.Fatalf("Next: %v", next)
+	}
+
+	every := RawJob{RawSpec: "@every 90s", Sec: "1"}
+	if sched, err = every.Schedule(); err != nil || sched.Next(sat).Sub(sat) != 90*time.Second {
+		t.Fatalf("@every: %v", err)
+	}
+
+	monthly := RawJob{RawSpec: "0 0 0 1 * ?", TZ: "Asia/Shanghai"}
+	if sched, err = monthly.Schedule(); err != nil {
+		t.Fatal(err)
+	}
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	if next := sched.Next(sat).In(loc); next.Day() != 1 || next.Hour() != 0 {
+		t.Fatalf("tz: %v", next)
+	}
+
+	for _, rj := range []RawJob{{Dom: "32"}, {RawSpec: "@sometimes"}, {TZ: "Mars/Base"}} {
+		if rj.Valid() == nil {
+			t.Fatalf("expect invalid: %v", rj)
+		}
+	}
+}
+
+func TestCrontabReload(t *testing.T) {
+	dir := t.TempDir()
+	cronFile := filepath.Join(dir, "crontab.lua")
+	write := func(jobs string) {
+		if err := os.WriteFile(cronFile, []byte("setJobs({"+jobs+"})"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ct := Crontab{}
+	defer ct.Stop()
+	nop := func(L *lua.LState) error { return nil }
+
+	write(`{id='a', spec='@hourly', doFile='a.lua'}, {spec='@daily', doFile='b.lua'}`)
+	report, err := ct.Load(cronFile, nop)
+	if err != nil || len(report.Added) != 2 || len(report.Removed)+len(report.Updated) != 0 {
+		t.Fatalf("first load: %+v, %v", report, err)
+	}
+	a := ct.jobs["a"]
+
+	report, err = ct.Load(cronFile, nop)
+	if err != nil || report.Changed() || ct.jobs["a"] != a {
+		t.Fatalf("unchanged: %+v, %v", report, err)
+	}
+
+	// a run in progress is in the status, and recorded after the job is updated
+	release, ended := make(chan struct{}), make(chan struct{})
+	a.done = func() { close(ended) }
+	a.start(func(jobRun) error { <-release; return nil }, time.Now(), true)
+	statusOf := func(id string) JobStatus {
+		for _, st := range ct.Status() {
+			if st.ID == id {
+				return st
+			}
+		}
+		t.Fatalf("no status of %s", id)
+		return JobStatus{}
+	}
+	for i := 0; i < 100 && statusOf("a").LastStart.IsZero(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := statusOf("a"); st.LastStart.IsZero() || !st.LastEnd.IsZero() || st.Running != 1 {
+		t.Fatalf("in progress: %+v", st)
+	}
+
+	write(`{id='a', spec='@every 1h', doFile='a.lua'}, {spec='@weekly', doFile='b.lua'}`)
+	report, err = ct.Load(cronFile, nop)
+	if err != nil || len(report.Updated) != 1 || report.Updated[0] != "a" ||
+		len(report.Added) != 1 || len(report.Removed) != 1 {
+		t.Fatalf("changed: %+v, %v", report, err)
+	}
+	select {
+	case <-a.stop:
+	default:
+		t.Fatal("the updated job is still running")
+	}
+	close(release)
+	<-ended
+	if st := statusOf("a"); st.Successes != 1 || st.LastEnd.IsZero() {
+		t.Fatalf("the run of the replaced job: %+v", st)
+	}
+
+	// the history of a removed job is dropped, not restored when it's added back
+	write(`{spec='@weekly', doFile='b.lua'}`)
+	if report, err = ct.Load(cronFile, nop); err != nil || len(report.Removed) != 1 {
+		t.Fatalf("removed: %+v, %v", report, err)
+	}
+	write(`{id='a', spec='@every 1h', doFile='a.lua'}, {spec='@weekly', doFile='b.lua'}`)
+	if report, err = ct.Load(cronFile, nop); err != nil || len(report.Added) != 1 || statusOf("a").Successes != 0 {
+		t.Fatalf("added back: %+v, %v", report, err)
+	}
+
+	write(`{id='a', spec='@hourly'}, {id='a', spec='@daily'}`)
+	if _, err = ct.Load(cronFile, nop); err == nil {
+		t.Fatal("expect the duplicate id")
+	}
+}
+
+func TestCrontabPause(t *testing.T) {
+	dir := t.TempDir()
+	cronFile := filepath.Join(dir, "crontab.lua")
+	counter := filepath.Join(dir, "job.lua")
+	os.WriteFile(counter, []byte("count()"), 0644)
+	write := func(spec string) {
+		jobs := "setJobs({{id='a', spec='" + spec + "', lvm=2, overlap='allow', doFile='" + counter + "'}, {id='b', spec='@hourly', doFile='" + counter + "'}})"
+		if err := os.WriteFile(cronFile, []byte(jobs), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ct := Crontab{}
+	defer ct.Stop()
+	var n int32
+	plf := func(L *lua.LState) error {
+		L.Register("count", func(L *lua.LState) int {
+			atomic.AddInt32(&n, 1)
+			return 0
+		})
+		return nil
+	}
+	runs := func() int { return int(atomic.LoadInt32(&n)) }
+
+	write("@every 1s")
+	if _, err := ct.Load(cronFile, plf); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.Pause("a"); err != nil {
+		t.Fatal(err)
+	}
+	write("@every 2s")
+	if report, err := ct.Load(cronFile, plf); err != nil || len(report.Updated) != 1 {
+		t.Fatalf("reload: %+v, %v", report, err)
+	}
+	if st := ct.Status(); !st[0].Paused || st[1].Paused {
+		t.Fatalf("the pause isn't kept: %+v", st)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if n := runs(); n != 0 {
+		t.Fatalf("the paused job ran %d times", n)
+	}
+
+	if err := ct.RunNow("a"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && runs() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runs(); n != 1 {
+		t.Fatalf("RunNow: runs=%d", n)
+	}
+
+	ct.PauseAll()
+	if st := ct.Status(); !st[0].Paused || !st[1].Paused {
+		t.Fatalf("PauseAll: %+v", st)
+	}
+	ct.ResumeAll()
+	if st := ct.Status(); st[0].Paused || st[1].Paused {
+		t.Fatalf("ResumeAll: %+v", st)
+	}
+	if ct.Pause("nope") == nil || ct.RunNow("nope") == nil {
+		t.Fatal("expect the unknown job")
+	}
+}
@@ -0,0 +1,194 @@
+//Lua.go
+
+//Bind golang functions to lua by generics, without reflect.Value.Call on every call
+package base
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Func0 ~ Func3 bind functions returning one value, the result of a failed argument
+// conversion is the error string, the same as the functions bound by ParseStruct.
+//
+//	funcs["add"] = Func2(func(a, b int) int { return a + b })
+func Func0[R any](f func() R) lua.LGFunction {
+	return func(L *lua.LState) int {
+		L.Push(toLValue(L, f()))
+		return 1
+	}
+}
+
+func Func1[A, R any](f func(A) R) lua.LGFunction {
+	return func(L *lua.LState) int {
+		a, err := checkArg[A](L, 1, 1)
+		if err != nil {
+			return SetErrorOutputs(L, 1, err)
+		}
+		L.Push(toLValue(L, f(a)))
+		return 1
+	}
+}
+
+func Func2[A, B, R any](f func(A, B) R) lua.LGFunction {
+	return func(L *lua.LState) int {
+		a, err := checkArg[A](L, 1, 2)
+		if err != nil {
+			return SetErrorOutputs(L, 1, err)
+		}
+		b, err := checkArg[B](L, 2, 2)
+		if err != nil {
+			return SetErrorOutputs(L, 1, err)
+		}
+		L.Push(toLValue(L, f(a, b)))
+		return 1
+	}
+}
+
+func Func3[A, B, C, R any](f func(A, B, C) R) lua.LGFunction {
+	return func(L *lua.LState) int {
+		a, err := checkArg[A](L, 1, 3)
+		if err != nil {
+			return SetErrorOutputs(L, 1, err)
+		}
+		b, err := checkArg[B](L, 2, 3)
+		if err != nil {
+			return SetErrorOutputs(L, 1, err)
+		}
+		c, err := checkArg[C](L, 3, 3)
+		if err != nil {
+			return SetErrorOutputs(L, 1, err)
+		}
+		L.Push(toLValue(L, f(a, b, c)))
+		return 1
+	}
+}
+
+// Proc1 ~ Proc3 bind functions without return value, a failed argument conversion
+// raises an error, the same as the functions bound by ParseStruct.
+func Proc1[A any](f func(A)) lua.LGFunction {
+	return func(L *lua.LState) int {
+		a, err := checkArg[A](L, 1, 1)
+		if err != nil {
+			L.ArgError(1, err.Error())
+			return 0
+		}
+		f(a)
+		return 0
+	}
+}
+
+func Proc2[A, B any](f func(A, B)) lua.LGFunction {
+	return func(L *lua.LState) int {
+		a, err := checkArg[A](L, 1, 2)
+		if err != nil {
+			L.ArgError(1, err.Error())
+			return 0
+		}
+		b, err := checkArg[B](L, 2, 2)
+		if err != nil {
+			L.ArgError(2, err.Error())
+			return 0
+		}
+		f(a, b)
+		return 0
+	}
+}
+
+func Proc3[A, B, C any](f func(A, B, C)) lua.LGFunction {
+	return func(L *lua.LState) int {
+		a, err := checkArg[A](L, 1, 3)
+		if err != nil {
+			L.ArgError(1, err.Error())
+			return 0
+		}
+		b, err := checkArg[B](L, 2, 3)
+		if err != nil {
+			L.ArgError(2, err.Error())
+			return 0
+		}
+		c, err := checkArg[C](L, 3, 3)
+		if err != nil {
+			L.ArgError(3, err.Error())
+			return 0
+		}
+		f(a, b, c)
+		return 0
+	}
+}
+
+//==================================
+// the n-th argument of the call, numIn is the count of the function's arguments
+func checkArg[T any](L *lua.LState, n, numIn int) (ret T, err error) {
+	if L.GetTop() < numIn {
+		err = fmt.Errorf("Invalid input arguments. Need %d inputs at least.", numIn)
+		return
+	}
+	lv := L.Get(n)
+	// the fast path, others are converted by ParseLValue
+	switch p := any(&ret).(type) {
+	case *string:
+		if s, ok := lv.(lua.LString); ok {
+			*p = string(s)
+			return
+		}
+	case *bool:
+		if b, ok := lv.(lua.LBool); ok {
+			*p = bool(b)
+			return
+		}
+	case *float64:
+		if f, ok := lv.(lua.LNumber); ok {
+			*p = float64(f)
+			return
+		}
+	case *int:
+		// -float64(math.MinInt) as float64(math.MaxInt) is rounded up on the 64-bit platforms
+		if i, ok := lv.(lua.LNumber); ok && isIntegral(i) && float64(i) >= math.MinInt && float64(i) < -float64(math.MinInt) {
+			*p = int(i)
+			return
+		}
+	case *int64:
+		if i, ok := lv.(lua.LNumber); ok && isIntegral(i) {
+			*p = int64(i)
+			return
+		}
+	case *lua.LValue:
+		*p = lv
+		return
+	}
+	var rv reflect.Value
+	if rv, err = ParseLValue(L, lv, reflect.TypeOf(&ret).Elem()); err != nil || !rv.IsValid() {
+		return
+	}
+	var ok bool
+	if ret, ok = rv.Interface().(T); !ok {
+		err = fmt.Errorf("invalid value type, expect %v, got %s.", reflect.TypeOf(&ret).Elem(), rv.Type())
+	}
+	return
+}
+
+func isIntegral(n lua.LNumber) bool {
+	f := float64(n)
+	return f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+}
+
+func toLValue[T any](L *lua.LState, v T) lua.LValue {
+	switch x := any(v).(type) {
+	case string:
+		return lua.LString(x)
+	case bool:
+		return lua.LBool(x)
+	case float64:
+		return lua.LNumber(x)
+	case int:
+		return int2LuaValue(L, int64(x))
+	case lua.LValue:
+		return x
+	default:
+		return go2LuaValue(L, reflect.ValueOf(&v).Elem())
+	}
+}
@@ -0,0 +1,65 @@
+//
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestGenericFuncs(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	type ScorePair struct {
+		Score  int64
+		Member string
+	}
+	RegisterUserData(L, ScorePair{})
+
+	L.SetGlobal("add", L.NewFunction(Func2(func(a, b int) int { return a + b })))
+	L.SetGlobal("join", L.NewFunction(Func2(strings.Repeat)))
+	L.SetGlobal("score", L.NewFunction(Func1(func(s ScorePair) int64 { return s.Score })))
+	L.SetGlobal("half", L.NewFunction(Func1(func(f float32) float32 { return f / 2 })))
+
+	if err := L.DoString(`
+    assert(add(1, 2)==3)
+    assert(add(1.5, 2)=='invalid value type, expect int, got float64.')
+    assert(add(2^63, 1)=='invalid value type, expect int, got float64.')
+    assert(add(1, -2^63)==1-2^63)
+    assert(add(1)=='Invalid input arguments. Need 2 inputs at least.')
+    assert(join('ab', 3)=='ababab')
+    assert(score(scorepair.new(100, "mem"))==100)
+    assert(half(3)==1.5)
+    `); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func benchmarkLuaCall(b *testing.B, fn lua.LGFunction) {
+	L := lua.NewState()
+	defer L.Close()
+
+	f := L.NewFunction(fn)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := L.CallByParam(lua.P{Fn: f, NRet: 1}, lua.LNumber(i), lua.LNumber(1)); err != nil {
+			b.Fatal(err)
+		}
+		L.Pop(1)
+	}
+}
+
+func BenchmarkReflectCall(b *testing.B) {
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(struct {
+		Add func(int, int) int
+	}{func(a, b int) int { return a + b }}, funcs)
+	benchmarkLuaCall(b, funcs["add"])
+}
+
+func BenchmarkGenericCall(b *testing.B) {
+	benchmarkLuaCall(b, Func2(func(a, b int) int { return a + b }))
+}
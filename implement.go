@@ -0,0 +1,236 @@
+//Lua.go
+
+//Reverse binding: golang values whose functions dispatch into the functions of lua tables
+package base
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// LuaObject is a lua table seen from golang, the methods are called with the table as self.
+// Like lua.LState, it must not be used by multiple goroutines at the same time.
+type LuaObject struct {
+	L     *lua.LState
+	Table *lua.LTable
+
+	err error // the first error of Bind
+}
+
+var (
+	adapters    = make(map[reflect.Type]func(*LuaObject) reflect.Value)
+	adapterLock sync.RWMutex
+)
+
+// RegisterInterface registers the adapter which Implement uses to build T, golang can't
+// create the methods of an interface at runtime.
+//
+//	type luaHandler struct{ handle func(*Request) (*Response, error) }
+//	func (h *luaHandler) Handle(req *Request) (*Response, error) { return h.handle(req) }
+//
+//	RegisterInterface(func(o *LuaObject) Handler {
+//		h := &luaHandler{}
+//		o.Bind("Handle", &h.handle)
+//		return h
+//	})
+func RegisterInterface[T any](factory func(*LuaObject) T) {
+	tp := reflect.TypeOf((*T)(nil)).Elem()
+	adapterLock.Lock()
+	defer adapterLock.Unlock()
+	adapters[tp] = func(o *LuaObject) reflect.Value {
+		v := factory(o)
+		return reflect.ValueOf(&v).Elem()
+	}
+}
+
+// Implement builds T from lv:
+//
+//	func type              lv is a lua function
+//	struct of func fields  lv is a table, every func field is a method of it
+//	interface              lv is a table, T is registered by RegisterInterface
+//
+// Every method must exist in the table, as the lower-cased or the golang name.
+// The arguments and returns are converted by go2LuaValue and ParseLValue; a lua
+// error, or a string in the place of the error output, becomes the error output
+// if the function has one, otherwise it panics.
+func Implement[T any](L *lua.LState, lv lua.LValue) (T, error) {
+	var zero T
+	tp := reflect.TypeOf((*T)(nil)).Elem()
+	switch tp.Kind() {
+	case reflect.Func:
+		fn, ok := lv.(*lua.LFunction)
+		if !ok {
+			return zero, fmt.Errorf("Implement: expect function for %v, got %s.", tp, lv.Type())
+		}
+		return luaFunc(L, fn, nil, tp).Interface().(T), nil
+	case reflect.Struct:
+		o, err := newLuaObject(L, lv, tp)
+		if err != nil {
+			return zero, err
+		}
+		rv := reflect.New(tp).Elem()
+		for i := 0; i < tp.NumField(); i++ {
+			f := tp.Field(i)
+			if f.Type.Kind() != reflect.Func || !rv.Field(i).CanSet() {
+				continue
+			}
+			if err := o.bindValue(f.Name, rv.Field(i)); err != nil {
+				return zero, err
+			}
+		}
+		return rv.Interface().(T), nil
+	case reflect.Interface:
+		adapterLock.RLock()
+		factory, ok := adapters[tp]
+		adapterLock.RUnlock()
+		if !ok {
+			return zero, fmt.Errorf("Implement: %v is not registered by RegisterInterface.", tp)
+		}
+		o, err := newLuaObject(L, lv, tp)
+		if err != nil {
+			return zero, err
+		}
+		for i := 0; i < tp.NumMethod(); i++ {
+			if _, err := o.method(tp.Method(i).Name); err != nil {
+				return zero, err
+			}
+		}
+		rv := factory(o)
+		if o.err != nil {
+			return zero, o.err
+		}
+		return rv.Interface().(T), nil
+	}
+	return zero, fmt.Errorf("Implement: unsupported type %v.", tp)
+}
+
+func newLuaObject(L *lua.LState, lv lua.LValue, tp reflect.Type) (*LuaObject, error) {
+	t, ok := lv.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("Implement: expect table for %v, got %s.", tp, lv.Type())
+	}
+	return &LuaObject{L: L, Table: t}, nil
+}
+
+// Bind sets *fnPtr, a pointer to a func variable or field, to call the method name.
+// The error of a missing method is returned by Implement.
+func (o *LuaObject) Bind(name string, fnPtr interface{}) {
+	rv := reflect.ValueOf(fnPtr)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Func {
+		panic(fmt.Sprintf("LuaObject.Bind: expect a pointer to func, got %T.", fnPtr))
+	}
+	if err := o.bindValue(name, rv.Elem()); err != nil && o.err == nil {
+		o.err = err
+	}
+}
+
+func (o *LuaObject) bindValue(name string, fv reflect.Value) error {
+	fn, err := o.method(name)
+	if err != nil {
+		return err
+	}
+	fv.Set(luaFunc(o.L, fn, o.Table, fv.Type()))
+	return nil
+}
+
+// the field of the table, the lower-cased name first
+func (o *LuaObject) method(name string) (*lua.LFunction, error) {
+	for _, key := range []string{strings.ToLower(name), name} {
+		if fn, ok := o.L.GetField(o.Table, key).(*lua.LFunction); ok {
+			return fn, nil
+		}
+	}
+	return nil, fmt.Errorf("Implement: method %s is missing in the table.", name)
+}
+
+// a golang function of type tp which calls fn, self is the first argument if it isn't nil
+func luaFunc(L *lua.LState, fn *lua.LFunction, self lua.LValue, tp reflect.Type) reflect.Value {
+	numOut := tp.NumOut()
+	hasErr := numOut > 0 && tp.Out(numOut-1) == errorInterface
+
+	return reflect.MakeFunc(tp, func(in []reflect.Value) []reflect.Value {
+		outs := make([]reflect.Value, numOut)
+		for i := range outs {
+			outs[i] = reflect.Zero(tp.Out(i))
+		}
+		fail := func(err error) []reflect.Value {
+			if !hasErr {
+				panic(err)
+			}
+			outs[numOut-1] = reflect.ValueOf(&err).Elem()
+			return outs
+		}
+
+		args := make([]lua.LValue, 0, len(in)+1)
+		if self != nil {
+			args = append(args, self)
+		}
+		if err := protectedConvert(L, func(L *lua.LState) error {
+			for i, v := range in {
+				if tp.IsVariadic() && i == len(in)-1 {
+					for j := 0; j < v.Len(); j++ {
+						args = append(args, go2LuaValue(L, v.Index(j)))
+					}
+					break
+				}
+				args = append(args, go2LuaValue(L, v))
+			}
+			return nil
+		}); err != nil {
+			return fail(err)
+		}
+
+		if err := L.CallByParam(lua.P{Fn: fn, NRet: numOut, Protect: true}, args...); err != nil {
+			return fail(err)
+		}
+		rets := make([]lua.LValue, numOut)
+		for i := numOut - 1; i >= 0; i-- {
+			rets[i] = L.Get(-1)
+			L.Pop(1)
+		}
+
+		n := numOut
+		if hasErr {
+			switch lerr := rets[numOut-1].(type) {
+			case *lua.LNilType:
+			case lua.LString:
+				return fail(errors.New(string(lerr)))
+			default:
+				return fail(fmt.Errorf("%s", lerr))
+			}
+			n--
+		}
+		if err := protectedConvert(L, func(L *lua.LState) error {
+			for i := 0; i < n; i++ {
+				v, err := ParseLValue(L, rets[i], tp.Out(i))
+				if err != nil {
+					return err
+				}
+				outs[i] = proxyValue(v, tp.Out(i))
+			}
+			return nil
+		}); err != nil {
+			return fail(err)
+		}
+		return outs
+	})
+}
+
+// the conversions raise lua errors (ArgError, the limits), so they run in a protected
+// call and the errors are returned to the golang caller
+func protectedConvert(L *lua.LState, f func(*lua.LState) error) error {
+	var err error
+	L.Push(L.NewFunction(func(L2 *lua.LState) int {
+		err = f(L2)
+		return 0
+	}))
+	if perr := L.PCall(0, 0, nil); perr != nil {
+		return perr
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+//
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+type greeter interface {
+	Greet(name string) (string, error)
+}
+
+type luaGreeter struct {
+	greet func(string) (string, error)
+}
+
+func (g *luaGreeter) Greet(name string) (string, error) { return g.greet(name) }
+
+func TestImplement(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	RegisterInterface(func(o *LuaObject) greeter {
+		g := &luaGreeter{}
+		o.Bind("Greet", &g.greet)
+		return g
+	})
+
+	if err := L.DoString(`
+    greeter = {prefix = "hello "}
+    function greeter:greet(name)
+        if name == "" then return nil, "empty name" end
+        return self.prefix .. name
+    end
+    function add(a, b) return a + b end
+    calc = {
+        sum = function(self, ...)
+            local s = 0
+            for _, n in ipairs({...}) do s = s + n end
+            return s
+        end,
+        fail = function(self) error("boom") end,
+        fn = function(self) return print end,
+        fnint = function(self) return print end,
+        fnpanic = function(self) return print end,
+        deep = function(self, t) return #t end,
+    }
+    `); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := Implement[greeter](L, L.GetGlobal("greeter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := g.Greet("lua"); err != nil || s != "hello lua" {
+		t.Fatalf("Greet: %q, %v", s, err)
+	}
+	if _, err := g.Greet(""); err == nil || err.Error() != "empty name" {
+		t.Fatalf("Greet: expect 'empty name', got %v", err)
+	}
+
+	add, err := Implement[func(int, int) int](L, L.GetGlobal("add"))
+	if err != nil || add(1, 2) != 3 {
+		t.Fatalf("add: %v", err)
+	}
+
+	calc, err := Implement[struct {
+		Sum  func(...int) int
+		Fail func() error
+	}](L, L.GetGlobal("calc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := calc.Sum(1, 2, 3); n != 6 {
+		t.Fatalf("Sum: %d", n)
+	}
+	if err := calc.Fail(); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Fail: %v", err)
+	}
+
+	// the type-mismatched returns and the arguments over the limits are errors, not crashes
+	bad, err := Implement[struct {
+		Fn      func() (interface{}, error)
+		FnInt   func() (int, error)
+		FnPanic func() interface{}
+		Deep    func([]int) (int, error)
+	}](L, L.GetGlobal("calc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bad.Fn(); err == nil {
+		t.Fatal("Fn: expect the unsupported type")
+	}
+	if _, err := bad.FnInt(); err == nil {
+		t.Fatal("FnInt: expect the invalid value type")
+	}
+	func() {
+		defer func() {
+			if _, ok := recover().(error); !ok {
+				t.Fatal("FnPanic: expect to panic with the error")
+			}
+		}()
+		bad.FnPanic()
+	}()
+	SetConvertLimits(L, 0, 2)
+	if _, err := bad.Deep([]int{1, 2, 3}); err == nil {
+		t.Fatal("Deep: expect the element limit")
+	}
+	SetConvertLimits(L, defaultConvDepth, 0)
+
+	if _, err := Implement[struct{ Missing func() }](L, L.GetGlobal("calc")); err == nil ||
+		!strings.Contains(err.Error(), "method Missing is missing") {
+		t.Fatalf("expect the missing method, got %v", err)
+	}
+	if _, err := Implement[greeter](L, L.GetGlobal("calc")); err == nil {
+		t.Fatal("expect the missing method Greet")
+	}
+	if _, err := Implement[interface{ Close() error }](L, L.GetGlobal("calc")); err == nil {
+		t.Fatal("expect the unregistered interface")
+	}
+}
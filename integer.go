@@ -0,0 +1,299 @@
+//Lua.go
+
+//Keep the precision of int64/uint64 across the lua boundary, lua numbers are float64
+package base
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// IntegerMode decides how go2LuaValue pushes the integers which can't be
+// represented exactly by a lua number (|n| > 2^53).
+type IntegerMode int
+
+const (
+	IntegerAsNumber   IntegerMode = iota // lua number, precision is lost
+	IntegerAsString                      // decimal string, ParseLValue parses it back
+	IntegerAsUserData                    // userdata 'int64' or 'uint64'
+)
+
+const (
+	maxExactInt = 1 << 53 // max integer can be represented exactly by float64
+
+	int64TypeName  = "int64"
+	uint64TypeName = "uint64"
+)
+
+// SetIntegerMode sets the IntegerMode of L, IntegerAsNumber by default
+func SetIntegerMode(L *lua.LState, mode IntegerMode) {
+	settingsOf(L).integerMode = mode
+}
+
+func int2LuaValue(L *lua.LState, n int64) lua.LValue {
+	if -maxExactInt <= n && n <= maxExactInt {
+		return lua.LNumber(n)
+	}
+	switch settingsOf(L).integerMode {
+	case IntegerAsString:
+		return lua.LString(strconv.FormatInt(n, 10))
+	case IntegerAsUserData:
+		return createIntegerUserData(L, n, int64TypeName)
+	default:
+		return lua.LNumber(n)
+	}
+}
+
+func uint2LuaValue(L *lua.LState, n uint64) lua.LValue {
+	if n <= maxExactInt {
+		return lua.LNumber(n)
+	}
+	switch settingsOf(L).integerMode {
+	case IntegerAsString:
+		return lua.LString(strconv.FormatUint(n, 10))
+	case IntegerAsUserData:
+		return createIntegerUserData(L, n, uint64TypeName)
+	default:
+		return lua.LNumber(n)
+	}
+}
+
+// rv is an integer, expect is the kind of integer or float; no wraparound
+func convertInteger(rv reflect.Value, expect reflect.Type) (reflect.Value, error) {
+	out := reflect.New(expect).Elem()
+	signed := rv.Kind() >= reflect.Int && rv.Kind() <= reflect.Int64
+	switch expect.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if signed {
+			n = rv.Int()
+		} else if u := rv.Uint(); u <= math.MaxInt64 {
+			n = int64(u)
+		} else {
+			return out, fmt.Errorf("value %d out of range for %v.", u, expect)
+		}
+		if out.OverflowInt(n) {
+			return out, fmt.Errorf("value %d out of range for %v.", n, expect)
+		}
+		out.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		if !signed {
+			u = rv.Uint()
+		} else if n := rv.Int(); n >= 0 {
+			u = uint64(n)
+		} else {
+			return out, fmt.Errorf("value %d out of range for %v.", n, expect)
+		}
+		if out.OverflowUint(u) {
+			return out, fmt.Errorf("value %d out of range for %v.", u, expect)
+		}
+		out.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		if signed {
+			out.SetFloat(float64(rv.Int()))
+		} else {
+			out.SetFloat(float64(rv.Uint()))
+		}
+	default:
+		return rv, nil
+	}
+	return out, nil
+}
+
+// decimal string --> int64 or uint64
+func parseIntegerString(s string) (reflect.Value, bool) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return reflect.ValueOf(n), true
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return reflect.ValueOf(u), true
+	}
+	return reflect.Value{}, false
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Int64) || (k >= reflect.Uint && k <= reflect.Uintptr)
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return isIntegerKind(k) || k == reflect.Float32 || k == reflect.Float64
+}
+
+//==================================
+// userdata 'int64' and 'uint64', they share the metamethods so int64(1)==uint64(1)
+func createIntegerUserData(L *lua.LState, value interface{}, mtName string) *lua.LUserData {
+	if L.GetTypeMetatable(mtName) == lua.LNil {
+		registerIntegers(L)
+	}
+	ud := L.NewUserData()
+	ud.Value = value
+	L.SetMetatable(ud, L.GetTypeMetatable(mtName))
+	return ud
+}
+
+func registerIntegers(L *lua.LState) {
+	methods := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"tonumber": func(L2 *lua.LState) int {
+			f, _ := new(big.Float).SetInt(checkBigInt(L2, 1)).Float64()
+			L2.Push(lua.LNumber(f))
+			return 1
+		},
+		"tostring": integerToString,
+	})
+	// the same function objects for both types, the __eq of lua 5.1 needs it
+	metamethods := map[string]lua.LGFunction{
+		"__tostring": integerToString,
+		"__eq":       integerCompare(func(c int) bool { return c == 0 }),
+		"__lt":       integerCompare(func(c int) bool { return c < 0 }),
+		"__le":       integerCompare(func(c int) bool { return c <= 0 }),
+		"__add":      integerArith((*big.Int).Add, false),
+		"__sub":      integerArith((*big.Int).Sub, false),
+		"__mul":      integerArith((*big.Int).Mul, false),
+		"__div":      integerArith((*big.Int).Quo, true),
+		"__mod":      integerArith((*big.Int).Rem, true),
+		"__unm": func(L2 *lua.LState) int {
+			L2.Push(bigInt2LuaValue(L2, new(big.Int).Neg(checkBigInt(L2, 1)), integerTypeName(L2.Get(1))))
+			return 1
+		},
+	}
+	fns := make(map[string]*lua.LFunction, len(metamethods))
+	for k, f := range metamethods {
+		fns[k] = L.NewFunction(f)
+	}
+	for _, mtName := range []string{int64TypeName, uint64TypeName} {
+		mtName := mtName
+		mt := L.NewTypeMetatable(mtName)
+		L.SetField(mt, "__index", methods)
+		for k, f := range fns {
+			L.SetField(mt, k, f)
+		}
+		// int64.new("9007199254740993")
+		L.SetField(mt, "new", L.NewFunction(func(L2 *lua.LState) int {
+			L2.Push(bigInt2LuaValue(L2, checkBigInt(L2, 1), mtName))
+			return 1
+		}))
+		L.SetGlobal(mtName, mt)
+	}
+}
+
+func integerToString(L *lua.LState) int {
+	L.Push(lua.LString(checkBigInt(L, 1).String()))
+	return 1
+}
+
+func integerCompare(cmp func(int) bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		L.Push(lua.LBool(cmp(checkBigInt(L, 1).Cmp(checkBigInt(L, 2)))))
+		return 1
+	}
+}
+
+func integerArith(op func(z, x, y *big.Int) *big.Int, div bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		x, y := checkBigInt(L, 1), checkBigInt(L, 2)
+		if div && y.Sign() == 0 {
+			L.RaiseError("integer divide by zero.")
+			return 0
+		}
+		tpName := integerTypeName(L.Get(1))
+		if len(tpName) == 0 {
+			tpName = integerTypeName(L.Get(2))
+		}
+		L.Push(bigInt2LuaValue(L, op(new(big.Int), x, y), tpName))
+		return 1
+	}
+}
+
+func integerTypeName(lv lua.LValue) string {
+	if ud, ok := lv.(*lua.LUserData); ok {
+		switch ud.Value.(type) {
+		case int64:
+			return int64TypeName
+		case uint64:
+			return uint64TypeName
+		}
+	}
+	return ""
+}
+
+// the n-th argument: userdata int64/uint64, integral number or decimal string
+func checkBigInt(L *lua.LState, n int) *big.Int {
+	switch lv := L.CheckAny(n).(type) {
+	case *lua.LUserData:
+		switch v := lv.Value.(type) {
+		case int64:
+			return big.NewInt(v)
+		case uint64:
+			return new(big.Int).SetUint64(v)
+		}
+	case lua.LNumber:
+		if isIntegral(lv) {
+			return big.NewInt(int64(lv))
+		}
+	case lua.LString:
+		if b, ok := new(big.Int).SetString(string(lv), 10); ok {
+			return b
+		}
+	}
+	L.ArgError(n, "integer expected, got "+L.CheckAny(n).String())
+	return nil
+}
+
+func bigInt2LuaValue(L *lua.LState, b *big.Int, mtName string) lua.LValue {
+	switch {
+	case mtName == uint64TypeName && b.IsUint64():
+		return createIntegerUserData(L, b.Uint64(), uint64TypeName)
+	case mtName != uint64TypeName && b.IsInt64():
+		return createIntegerUserData(L, b.Int64(), int64TypeName)
+	}
+	L.RaiseError("integer overflow: %s out of range for %s.", b, mtName)
+	return lua.LNil
+}
+
+//==================================
+// the numbers of the static bindings generated by cmd/luabind
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+type number interface {
+	integer | ~float32 | ~float64
+}
+
+// ParseNumber converts lv to T like ParseLValue: an error for a fraction or a value out
+// of the range of T, and the integers pushed by the IntegerMode of L are accepted.
+func ParseNumber[T number](L *lua.LState, lv lua.LValue) (T, error) {
+	tp := reflect.TypeOf(T(0))
+	rv, err := ParseLValue(L, lv, tp)
+	if err != nil {
+		return 0, err
+	}
+	if !rv.IsValid() || rv.Type() != tp {
+		return 0, fmt.Errorf("invalid value type, expect %v, got %s.", tp, lv.Type())
+	}
+	return rv.Interface().(T), nil
+}
+
+// CheckNumber is ParseNumber of the n-th argument, it raises an argument error
+func CheckNumber[T number](L *lua.LState, n int) T {
+	v, err := ParseNumber[T](L, L.CheckAny(n))
+	if err != nil {
+		L.ArgError(n, err.Error())
+	}
+	return v
+}
+
+// IntegerValue converts v by the IntegerMode of L, like go2LuaValue
+func IntegerValue[T integer](L *lua.LState, v T) lua.LValue {
+	var zero T
+	if zero-1 < zero {
+		return int2LuaValue(L, int64(v))
+	}
+	return uint2LuaValue(L, uint64(v))
+}
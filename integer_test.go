@@ -0,0 +1,72 @@
+//
+
+package base
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestIntegerFidelity(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(struct {
+		Id    func() int64
+		MaxU  func() uint64
+		Next  func(int64) int64
+		Byte  func(uint8) uint8
+		Float func(float64) float64
+	}{
+		Id:    func() int64 { return 1<<53 + 1 },
+		MaxU:  func() uint64 { return math.MaxUint64 },
+		Next:  func(n int64) int64 { return n + 1 },
+		Byte:  func(b uint8) uint8 { return b },
+		Float: func(f float64) float64 { return f },
+	}, funcs)
+	for k, f := range funcs {
+		L.SetGlobal(k, L.NewFunction(f))
+	}
+
+	// the numeric strings are integers only in IntegerAsString
+	if err := L.DoString(`
+    assert(next('5')=='invalid value type, expect int64, got string.')
+    `); err != nil {
+		t.Fatal(err)
+	}
+
+	SetIntegerMode(L, IntegerAsString)
+	if err := L.DoString(`
+    assert(id()=='9007199254740993')
+    assert(next(id())=='9007199254740994')
+    assert(maxu()=='18446744073709551615')
+    assert(byte(255)==255)
+    assert(byte(256)=='value 256 out of range for uint8.')
+    assert(byte(-1)=='value -1 out of range for uint8.')
+    assert(float(3)==3)
+    `); err != nil {
+		t.Fatal(err)
+	}
+
+	SetIntegerMode(L, IntegerAsUserData)
+	if err := L.DoString(`
+    local n = id()
+    assert(type(n)=='userdata')
+    assert(tostring(n)=='9007199254740993')
+    assert(tostring(next(n))=='9007199254740994')
+    assert(n + 1 == int64.new('9007199254740994'))
+    assert(n < n + 1)
+    assert(tostring(maxu())=='18446744073709551615')
+    assert(not pcall(function() return maxu() + 1 end))
+    `); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseLValue(L, lua.LNumber(-1), reflect.TypeOf(uint(0))); err == nil {
+		t.Fatal("expect out of range error for uint")
+	}
+}
@@ -0,0 +1,160 @@
+//Lua.go
+
+//Pass the args, the env and the run of a crontab job into its script
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// a run of a job, the script sees it as the global job and its args as ...
+type jobRun struct {
+	id        string
+	scheduled time.Time // when the run was due, the start time for RunNow
+	attempt   int       // 1 + the retries
+	args      []interface{}
+	env       map[string]string
+}
+
+func (rj RawJob) newRun(scheduled time.Time, attempt int) jobRun {
+	args, _ := rj.jobArgs() //checked by Valid
+	env, _ := rj.jobEnv()   //checked by Valid
+	return jobRun{id: rj.ID(), scheduled: scheduled, attempt: attempt, args: args, env: env}
+}
+
+// runs the script in a new LState, or the lvm of the job which runs one script at a time.
+// proto is the precompiled script, nil to load the file.
+func (rj RawJob) runScript(run jobRun, plf PreloadFunc, proto *lua.FunctionProto) error {
+	do := func(L *lua.LState) error {
+		args, restore := run.inject(L)
+		defer restore()
+		return execChunk(L, plf, func() (*lua.LFunction, error) {
+			if proto != nil {
+				return L.NewFunctionFromProto(proto), nil
+			}
+			return L.LoadFile(rj.FilePath)
+		}, args...)
+	}
+	if rj.LvmId == 0 {
+		L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+		defer L.Close()
+		return do(L)
+	}
+	return defaultLVMs.withLVM(rj.LvmId, do)
+}
+
+// sets the global job, makes os.getenv see the env of the job, and returns the args.
+// restore puts back the global job and os.getenv of a shared lvm after the run.
+func (run jobRun) inject(L *lua.LState) (args []lua.LValue, restore func()) {
+	args = make([]lua.LValue, len(run.args))
+	argt := L.CreateTable(len(run.args), 0)
+	for i, v := range run.args {
+		args[i] = json2LuaValue(L, v)
+		argt.Append(args[i])
+	}
+	envt := L.CreateTable(0, len(run.env))
+	for k, v := range run.env {
+		envt.RawSetString(k, lua.LString(v))
+	}
+	job := L.NewTable()
+	job.RawSetString("id", lua.LString(run.id))
+	job.RawSetString("scheduled", time2LuaValue(L, run.scheduled))
+	job.RawSetString("attempt", lua.LNumber(run.attempt))
+	job.RawSetString("args", argt)
+	job.RawSetString("env", envt)
+	prevJob := L.GetGlobal("job")
+	L.SetGlobal("job", job)
+	restore = func() { L.SetGlobal("job", prevJob) }
+
+	if osLib, ok := L.GetGlobal("os").(*lua.LTable); ok {
+		env, getenv := run.env, osLib.RawGetString("getenv")
+		restore = func() {
+			L.SetGlobal("job", prevJob)
+			osLib.RawSetString("getenv", getenv)
+		}
+		osLib.RawSetString("getenv", L.NewFunction(func(L2 *lua.LState) int {
+			key := L2.CheckString(1)
+			if v, ok := env[key]; ok {
+				L2.Push(lua.LString(v))
+			} else if v, ok := os.LookupEnv(key); ok {
+				L2.Push(lua.LString(v))
+			} else {
+				L2.Push(lua.LNil)
+			}
+			return 1
+		}))
+	}
+	return args, restore
+}
+
+//==================================
+// Args and Env are kept as json, so RawJob is still comparable
+
+func (rj RawJob) jobArgs() ([]interface{}, error) {
+	var args []interface{}
+	if err := decodeJobJSON(rj.Args, &args); err != nil {
+		return nil, fmt.Errorf("Invalid args: %v", err)
+	}
+	return args, nil
+}
+
+func (rj RawJob) jobEnv() (map[string]string, error) {
+	var env map[string]string
+	if err := decodeJobJSON(rj.Env, &env); err != nil {
+		return nil, fmt.Errorf("Invalid env: %v", err)
+	}
+	return env, nil
+}
+
+// the numbers are json.Number, so json2LuaValue keeps the integers
+func decodeJobJSON(s string, v interface{}) error {
+	if len(s) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// args={'acme', 7} in the crontab --> `["acme",7]`
+func jobArgs(v interface{}) (string, error) {
+	bt, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("args: %v", err)
+	}
+	switch string(bt) {
+	case "[]", "{}", "null": //{} is an empty lua table
+		return "", nil
+	}
+	if bt[0] != '[' {
+		return "", fmt.Errorf("args: expect list, got %T.", v)
+	}
+	return string(bt), nil
+}
+
+// env={TENANT='acme'} in the crontab --> `{"TENANT":"acme"}`, the keys are sorted
+func jobEnv(v interface{}) (string, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("env: expect map, got %T.", v)
+	}
+	if len(m) == 0 {
+		return "", nil
+	}
+	env := make(map[string]string, len(m))
+	for k, ev := range m {
+		s, err := jobString("env."+k, ev)
+		if err != nil {
+			return "", err
+		}
+		env[k] = s
+	}
+	bt, err := json.Marshal(env)
+	return string(bt), err
+}
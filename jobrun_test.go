@@ -0,0 +1,83 @@
+//
+
+package base
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestJobArgs(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "report.lua")
+	os.WriteFile(script, []byte(`
+local tenant, days = ...
+seen = string.format("%s %d %s %d %s %s %s", tenant, days, job.id, job.attempt,
+	job.args[1], job.env.TENANT, os.getenv("TENANT"))
+scheduled = job.scheduled:unix()`), 0644)
+	cronFile := filepath.Join(dir, "crontab.lua")
+	os.WriteFile(cronFile, []byte(`setJobs({
+	{id='acme', spec='@daily', lvm=3, args={'acme', 7}, env={TENANT='acme-env'}, doFile='`+script+`'},
+	{id='none', spec='@daily', args={}, env={}, doFile='`+script+`'}})`), 0644)
+
+	jobs, err := loadCrontab(cronFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].Args != `["acme",7]` || jobs[0].Env != `{"TENANT":"acme-env"}` || jobs[1].Args != "" || jobs[1].Env != "" {
+		t.Fatalf("jobs: %+v", jobs)
+	}
+
+	scheduled := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := jobs[0].runScript(jobs[0].newRun(scheduled, 2), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	var unix int64
+	DoScriptInLuaVM(3, "", func(L *lua.LState) error {
+		seen = L.GetGlobal("seen").String()
+		unix = int64(lua.LVAsNumber(L.GetGlobal("scheduled")))
+		return nil
+	})
+	if seen != "acme 7 acme 2 acme acme-env acme-env" || unix != scheduled.Unix() {
+		t.Fatalf("seen=%q, scheduled=%d", seen, unix)
+	}
+
+	// the shared lvm gets back its own os.getenv, and neither job nor time is left
+	var leaked string
+	DoScriptInLuaVM(3, "", func(L *lua.LState) error {
+		if err := L.DoString(`leaked = string.format("%s %s %s", tostring(os.getenv("TENANT")), type(job), type(time))`); err != nil {
+			return err
+		}
+		leaked = L.GetGlobal("leaked").String()
+		return nil
+	})
+	if want := "nil nil nil"; os.Getenv("TENANT") == "" && leaked != want {
+		t.Fatalf("leaked: %q", leaked)
+	}
+
+	// the attempts of a retried run
+	var attempts []int
+	cj := &cronJob{RawJob: jobs[0], stop: make(chan struct{}), retry: retryPolicy{retries: 1}}
+	cj.run(func(run jobRun) error {
+		attempts = append(attempts, run.attempt)
+		if run.attempt == 1 {
+			return os.ErrDeadlineExceeded
+		}
+		return nil
+	}, scheduled)
+	if len(attempts) != 2 || attempts[1] != 2 {
+		t.Fatalf("attempts: %v", attempts)
+	}
+
+	for _, bad := range []RawJob{{Args: `{"a":1}`}, {Env: `["a"]`}} {
+		bad.RawSpec = "@hourly"
+		if bad.Valid() == nil {
+			t.Fatalf("expect invalid: %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+//Lua.go
+
+//The lua module 'json': encode, decode, pretty, null and the array/object hints
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+const (
+	jsonArrayTypeName  = "json.array"
+	jsonObjectTypeName = "json.object"
+	jsonNullTypeName   = "json.null"
+)
+
+// the value of json.null, it is encoded as null by its MarshalJSON
+type jsonNull struct{}
+
+func (jsonNull) MarshalJSON() ([]byte, error) { return []byte("null"), nil }
+
+// LoadJSON preloads the module 'json':
+//
+//	local json = require("json")
+//	local s, err = json.encode({a = 1, b = json.array({})})  -- {"a":1,"b":[]}
+//	local v, err = json.decode(s)
+//	json.pretty(v [, indent]), json.object(t), json.null
+//
+// Decoded arrays and objects keep their hints, so an empty array is encoded as []
+// again; a table without a hint is an array if its keys are 1..n, otherwise an
+// object ({} for an empty table). Userdata are encoded by the json marshaling of
+// the golang value.
+func LoadJSON(L0 *lua.LState) {
+	L0.PreloadModule("json", func(L *lua.LState) int {
+		t := L.NewTable()
+		L.SetFuncs(t, map[string]lua.LGFunction{
+			"encode": func(L2 *lua.LState) int {
+				return jsonEncode(L2, "")
+			},
+			"pretty": func(L2 *lua.LState) int {
+				return jsonEncode(L2, L2.OptString(2, "  "))
+			},
+			"decode": jsonDecode,
+			"array": func(L2 *lua.LState) int {
+				return jsonHint(L2, jsonArrayTypeName)
+			},
+			"object": func(L2 *lua.LState) int {
+				return jsonHint(L2, jsonObjectTypeName)
+			},
+		})
+		consts := L.NewTable()
+		consts.RawSetString("null", jsonNullValue(L))
+		L.SetMetatable(t, newModuleMetatable(L, consts))
+		L.Push(t)
+		return 1
+	})
+}
+
+// the same userdata for every json.null of L, so v == json.null works
+func jsonNullValue(L *lua.LState) lua.LValue {
+	reg := L.Get(lua.RegistryIndex).(*lua.LTable)
+	if v := reg.RawGetString(jsonNullTypeName); v != lua.LNil {
+		return v
+	}
+	mt := L.NewTypeMetatable(jsonNullTypeName)
+	L.SetField(mt, "__tostring", L.NewFunction(func(L2 *lua.LState) int {
+		L2.Push(lua.LString("null"))
+		return 1
+	}))
+	ud := L.NewUserData()
+	ud.Value = jsonNull{}
+	L.SetMetatable(ud, mt)
+	reg.RawSetString(jsonNullTypeName, ud)
+	return ud
+}
+
+// json.array(t) / json.object(t), t is optional
+func jsonHint(L *lua.LState, tpName string) int {
+	t := L.OptTable(1, L.NewTable())
+	L.SetMetatable(t, jsonHintMetatable(L, tpName))
+	L.Push(t)
+	return 1
+}
+
+func jsonHintMetatable(L *lua.LState, tpName string) lua.LValue {
+	if mt := L.GetTypeMetatable(tpName); mt != lua.LNil {
+		return mt
+	}
+	return L.NewTypeMetatable(tpName)
+}
+
+//==================================
+func jsonEncode(L *lua.LState, indent string) int {
+	v, err := jsonValue(L, L.CheckAny(1))
+	var bt []byte
+	if err == nil {
+		if len(indent) > 0 {
+			bt, err = json.MarshalIndent(v, "", indent)
+		} else {
+			bt, err = json.Marshal(v)
+		}
+	}
+	if err != nil {
+		return SetErrorOutputs(L, 2, err)
+	}
+	L.Push(lua.LString(bt))
+	return 1
+}
+
+// lua value --> the golang value for json.Marshal, by the rules of lua2GoValue with the
+// array/object hints; the errors are returned, so the crontab loader can use it in a
+// callback too
+func jsonValue(L *lua.LState, lv lua.LValue) (interface{}, error) {
+	c := &luaToGo{isArray: func(t *lua.LTable) bool { return jsonIsArray(L, t) }}
+	rv, err := c.value(lv)
+	if err != nil {
+		return nil, fmt.Errorf("json: %v", err)
+	}
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	return rv.Interface(), nil
+}
+
+func jsonIsArray(L *lua.LState, t *lua.LTable) bool {
+	if mt := L.GetMetatable(t); mt != lua.LNil {
+		switch mt {
+		case L.GetTypeMetatable(jsonArrayTypeName):
+			return true
+		case L.GetTypeMetatable(jsonObjectTypeName):
+			return false
+		}
+	}
+	n, count := t.Len(), 0
+	if n == 0 {
+		return false
+	}
+	t.ForEach(func(_, _ lua.LValue) { count++ })
+	if count != n {
+		return false
+	}
+	for i := 1; i <= n; i++ {
+		if t.RawGetInt(i) == lua.LNil {
+			return false
+		}
+	}
+	return true
+}
+
+//==================================
+func jsonDecode(L *lua.LState) int {
+	dec := json.NewDecoder(strings.NewReader(L.CheckString(1)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return SetErrorOutputs(L, 2, fmt.Errorf("json: %v", err))
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return SetErrorOutputs(L, 2, fmt.Errorf("json: invalid data after the top-level value."))
+	}
+	L.Push(json2LuaValue(L, v))
+	return 1
+}
+
+// the numbers are converted by go2LuaValue, so the IntegerMode is applied
+func json2LuaValue(L *lua.LState, v interface{}) lua.LValue {
+	switch jv := v.(type) {
+	case nil:
+		return jsonNullValue(L)
+	case json.Number:
+		if n, ok := parseIntegerString(string(jv)); ok {
+			return go2LuaValue(L, n)
+		}
+		f, _ := jv.Float64()
+		return lua.LNumber(f)
+	case []interface{}:
+		t := L.CreateTable(len(jv), 0)
+		for _, ev := range jv {
+			t.Append(json2LuaValue(L, ev))
+		}
+		L.SetMetatable(t, jsonHintMetatable(L, jsonArrayTypeName))
+		return t
+	case map[string]interface{}:
+		t := L.CreateTable(0, len(jv))
+		for k, ev := range jv {
+			t.RawSetString(k, json2LuaValue(L, ev))
+		}
+		L.SetMetatable(t, jsonHintMetatable(L, jsonObjectTypeName))
+		return t
+	}
+	return go2LuaValue(L, reflect.ValueOf(v))
+}
@@ -0,0 +1,56 @@
+//
+
+package base
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestJSON(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	LoadJSON(L)
+	L.SetGlobal("moment", go2LuaValue(L, reflect.ValueOf(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))))
+
+	if err := L.DoString(`
+    local json = require("json")
+
+    assert(json.encode({1, 2, "x"})=='[1,2,"x"]')
+    assert(json.encode({b = true, a = 1.5})=='{"a":1.5,"b":true}')
+    assert(json.encode({})=='{}')
+    assert(json.encode(json.array())=='[]')
+    assert(json.encode(json.object({1}))=='{"1":1}')
+    assert(json.encode({v = json.null})=='{"v":null}')
+    assert(json.encode(moment)=='"2020-01-02T03:04:05Z"')
+
+    local v = json.decode('{"list":[],"obj":{},"n":null,"arr":[1,null,3],"f":0.5}')
+    assert(v.n==json.null and v.arr[2]==json.null and #v.arr==3)
+    assert(v.f==0.5)
+    assert(json.encode(v.list)=='[]' and json.encode(v.obj)=='{}')
+    assert(json.encode(v)=='{"arr":[1,null,3],"f":0.5,"list":[],"n":null,"obj":{}}')
+
+    assert(json.pretty({a = 1})=='{\n  "a": 1\n}')
+
+    local r, err = json.decode('{"a":')
+    assert(r==nil and err)
+    r, err = json.decode('1 2')
+    assert(r==nil and err)
+    local cyc = {}
+    cyc.self = cyc
+    r, err = json.encode(cyc)
+    assert(r==nil and string.find(err, "cycle", 1, true))
+    r, err = json.encode({f = print})
+    assert(r==nil and string.find(err, "unsupported type function", 1, true))
+    r, err = json.encode({1, 0/0})
+    assert(r==nil and string.find(err, "unsupported number", 1, true))
+
+    assert(not pcall(function() json.null = 1 end))
+    `); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,205 @@
+//Lua.go
+
+//The leases of the singleton crontab jobs, so only one replica runs every tick
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Locker grants the lease of a key to one owner until it expires. Crontab takes or
+// renews the lease "<job id>" before every scheduled run of a singleton job, the run is
+// left to the other replicas if it isn't granted.
+type Locker interface {
+	// TryLock takes or renews the lease of key for ttl, false if another owner holds it
+	TryLock(key, owner string, ttl time.Duration) (bool, error)
+}
+
+type lease struct {
+	Owner   string
+	Expires time.Time
+}
+
+func (l lease) grant(owner string, now time.Time) bool {
+	return l.Owner == owner || !now.Before(l.Expires)
+}
+
+//==================================
+// MemLocker keeps the leases in memory, for the tests and the crontabs of one process.
+// The zero value is ready to use.
+type MemLocker struct {
+	mu     sync.Mutex
+	leases map[string]lease
+}
+
+func NewMemLocker() *MemLocker {
+	return &MemLocker{leases: make(map[string]lease)}
+}
+
+func (m *MemLocker) TryLock(key, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leases == nil {
+		m.leases = make(map[string]lease)
+	}
+	for k, l := range m.leases {
+		if !now.Before(l.Expires) {
+			delete(m.leases, k)
+		}
+	}
+	if l, ok := m.leases[key]; ok && !l.grant(owner, now) {
+		return false, nil
+	}
+	m.leases[key] = lease{owner, now.Add(ttl)}
+	return true, nil
+}
+
+//==================================
+const (
+	defaultLockTTL = time.Minute
+
+	leaseExt     = ".lease"
+	guardTimeout = 10 * time.Second // a guard older than it is left by a crashed process
+)
+
+// FileLocker keeps every lease in a file of dir, for the replicas on the same host.
+// A lease file is changed under a guard file created with O_EXCL, so it works without
+// flock, and the leases expired for ttl are removed by TryLock.
+type FileLocker struct {
+	dir string
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewFileLocker(dir string) (*FileLocker, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileLocker{dir: dir}, nil
+}
+
+func (f *FileLocker) TryLock(key, owner string, ttl time.Duration) (bool, error) {
+	f.sweep(ttl)
+	path := filepath.Join(f.dir, url.QueryEscape(key)+leaseExt)
+	granted := false
+	err := withGuard(path, func() error {
+		now := time.Now()
+		l, err := readLease(path)
+		if err != nil {
+			return err
+		}
+		if !l.grant(owner, now) {
+			return nil
+		}
+		bt, _ := json.Marshal(lease{owner, now.Add(ttl)})
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, bt, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		granted = true
+		return nil
+	})
+	if err == errGuarded {
+		return false, nil //another replica is taking it
+	}
+	return granted, err
+}
+
+// the removed leases are expired for at least ttl, once per ttl
+func (f *FileLocker) sweep(ttl time.Duration) {
+	f.mu.Lock()
+	if time.Since(f.lastSweep) < ttl {
+		f.mu.Unlock()
+		return
+	}
+	f.lastSweep = time.Now()
+	f.mu.Unlock()
+
+	paths, _ := filepath.Glob(filepath.Join(f.dir, "*"+leaseExt))
+	for _, path := range paths {
+		withGuard(path, func() error {
+			if l, err := readLease(path); err == nil && time.Since(l.Expires) > ttl {
+				os.Remove(path)
+			}
+			return nil
+		})
+	}
+}
+
+// zero if the file doesn't exist
+func readLease(path string) (lease, error) {
+	var l lease
+	bt, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return l, err
+	}
+	if err := json.Unmarshal(bt, &l); err != nil {
+		return l, fmt.Errorf("Invalid lease file %s: %v", path, err)
+	}
+	return l, nil
+}
+
+var errGuarded = fmt.Errorf("The lease is being changed.")
+
+// runs f while holding path.guard
+func withGuard(path string, f func() error) error {
+	guard := path + ".guard"
+	for i := 0; ; i++ {
+		gf, err := os.OpenFile(guard, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			gf.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		if i > 0 || !takeOver(guard) {
+			return errGuarded
+		}
+	}
+	defer os.Remove(guard)
+	return f()
+}
+
+// removes the guard left by a crashed process. It's renamed away before the removal, so
+// of the replicas seeing it stale only one moves it, and a fresh guard created by the
+// winner meanwhile is linked back instead of being removed.
+func takeOver(guard string) bool {
+	st, err := os.Stat(guard)
+	if err != nil || time.Since(st.ModTime()) < guardTimeout {
+		return false
+	}
+	stale := fmt.Sprintf("%s.%d.%d", guard, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(guard, stale); err != nil {
+		return false //moved by another replica
+	}
+	defer os.Remove(stale)
+	if moved, err := os.Stat(stale); err != nil || !os.SameFile(st, moved) {
+		os.Link(stale, guard) //fails without overwriting if the guard is taken again
+		return false
+	}
+	return true
+}
+
+// hostname:pid
+func defaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil || len(strings.TrimSpace(host)) == 0 {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
@@ -0,0 +1,145 @@
+//
+
+package base
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockers(t *testing.T) {
+	fl, err := NewFileLocker(filepath.Join(t.TempDir(), "leases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, locker := range map[string]Locker{"mem": &MemLocker{}, "file": fl} {
+		lock := func(key, owner string, ttl time.Duration) bool {
+			ok, err := locker.TryLock(key, owner, ttl)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return ok
+		}
+		if !lock("job@1", "a", 50*time.Millisecond) || lock("job@1", "b", time.Minute) {
+			t.Fatalf("%s: the lease isn't exclusive", name)
+		}
+		if !lock("job@1", "a", 50*time.Millisecond) || !lock("job@2", "b", time.Minute) {
+			t.Fatalf("%s: renew or the other key", name)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if !lock("job@1", "b", time.Minute) {
+			t.Fatalf("%s: the expired lease isn't granted", name)
+		}
+	}
+
+	// a guard left by a crashed process
+	path := filepath.Join(fl.dir, "stale"+leaseExt)
+	os.WriteFile(path+".guard", nil, 0644)
+	if ok, _ := fl.TryLock("stale", "a", time.Minute); ok {
+		t.Fatal("expect the guarded lease")
+	}
+	old := time.Now().Add(-2 * guardTimeout)
+	os.Chtimes(path+".guard", old, old)
+	if ok, err := fl.TryLock("stale", "a", time.Minute); !ok || err != nil {
+		t.Fatalf("the stale guard: %v, %v", ok, err)
+	}
+
+	// of the replicas seeing the same stale guard, one takes it over
+	os.WriteFile(path+".guard", nil, 0644)
+	os.Chtimes(path+".guard", old, old)
+	var taken int32
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			if takeOver(path + ".guard") {
+				atomic.AddInt32(&taken, 1)
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+	if taken != 1 {
+		t.Fatalf("the stale guard is taken over %d times", taken)
+	}
+	// a fresh guard isn't taken over
+	os.WriteFile(path+".guard", nil, 0644)
+	if takeOver(path + ".guard") {
+		t.Fatal("the fresh guard is taken over")
+	}
+	os.Remove(path + ".guard")
+
+	// one lease file per job, whatever the ticks are
+	c := &Crontab{Locker: fl, Owner: "a"}
+	lease := c.leaseFunc("daily")
+	for i := int64(0); i < 3; i++ {
+		if !lease(time.Unix(100+i, 0)) {
+			t.Fatal("the lease isn't renewed by the owner")
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(fl.dir, "daily*")); len(files) != 1 {
+		t.Fatalf("lease files: %v", files)
+	}
+}
+
+func TestCrontabSingleton(t *testing.T) {
+	dir := t.TempDir()
+	cronFile := filepath.Join(dir, "crontab.lua")
+	script := filepath.Join(dir, "job.lua")
+	os.WriteFile(script, nil, 0644)
+	os.WriteFile(cronFile, []byte("setJobs({{id='s', spec='* * * * * ?', singleton=true, doFile='"+script+"'}})"), 0644)
+
+	if _, err := (&Crontab{}).Load(cronFile, nil); err == nil {
+		t.Fatal("expect the missing locker")
+	}
+	locker := &MemLocker{}
+	replicas := []*Crontab{{Locker: locker, Owner: "a"}, {Locker: locker, Owner: "b"}}
+	for _, ct := range replicas {
+		if _, err := ct.Load(cronFile, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer ct.Stop()
+	}
+	time.Sleep(2500 * time.Millisecond)
+
+	var runs, elsewhere int64
+	for _, ct := range replicas {
+		st := ct.Status()[0]
+		runs += st.Successes + st.Failures
+		elsewhere += st.Elsewhere
+	}
+	if runs < 2 || runs != elsewhere {
+		t.Fatalf("runs=%d, elsewhere=%d", runs, elsewhere)
+	}
+}
+
+func TestSingletonQueue(t *testing.T) {
+	locker := &MemLocker{}
+	var runs int32
+	release := make(chan struct{})
+	cmd := func(jobRun) error {
+		<-release
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}
+	replica := func(owner string) *cronJob {
+		c := &Crontab{Locker: locker, Owner: owner}
+		return &cronJob{RawJob: RawJob{Id: "q", Overlap: OverlapQueue}, stop: make(chan struct{}), lease: c.leaseFunc("q")}
+	}
+	a, b := replica("a"), replica("b")
+	t1, t2 := time.Unix(100, 0), time.Unix(101, 0)
+	a.tick(cmd, t1)
+	a.tick(cmd, t2) //queued behind t1
+	b.tick(cmd, t2)
+	close(release)
+	for i := 0; i < 100 && (a.status().Running > 0 || b.status().Running > 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runs != 2 || b.status().Elsewhere != 1 {
+		t.Fatalf("runs=%d, b=%+v", runs, b.status())
+	}
+}
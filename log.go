@@ -5,7 +5,6 @@ package base
 
 import (
 	"log"
-	"strings"
 
 	"github.com/yuin/gopher-lua"
 )
@@ -35,25 +34,7 @@ func LoadLogger(L0 *lua.LState) {
 	L0.PreloadModule("log", func(L *lua.LState) int {
 		t := L.NewTable()
 		L.SetFuncs(t, fucs)
-		mt := L.NewTable()
-		//Ignore the case of the function's name
-		L.SetField(mt, "__index", L.NewFunction(func(L2 *lua.LState) int {
-			t := L2.CheckTable(1)
-			key := L2.CheckString(2)
-			if f := t.RawGetString(strings.ToLower(key)); f.Type() == lua.LTFunction {
-				L2.Push(f)
-				return 1
-			} else {
-				L2.ArgError(2, "unknown func "+key)
-				return 0
-			}
-		}))
-		//Forbidden to add anything
-		L.SetField(mt, "__newindex", L.NewFunction(func(L2 *lua.LState) int {
-			L2.ArgError(2, "You are not allowed to add field.")
-			return 0
-		}))
-		L.SetMetatable(t, mt)
+		L.SetMetatable(t, newModuleMetatable(L, nil))
 		L.Push(t)
 		return 1
 	})
@@ -0,0 +1,191 @@
+//Lua.go
+
+//Convert time.Time and time.Duration between golang and lua
+package base
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// TimeMode decides how go2LuaValue pushes time.Time.
+type TimeMode int
+
+const (
+	TimeAsUserData TimeMode = iota // userdata 'time'
+	TimeAsUnix                     // unix seconds
+)
+
+const timeTypeName = "time"
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// SetTimeMode sets the TimeMode of L, TimeAsUserData by default
+func SetTimeMode(L *lua.LState, mode TimeMode) {
+	settingsOf(L).timeMode = mode
+}
+
+func time2LuaValue(L *lua.LState, t time.Time) lua.LValue {
+	if settingsOf(L).timeMode == TimeAsUnix {
+		return lua.LNumber(t.Unix())
+	}
+	ud := L.NewUserData()
+	ud.Value = t
+	L.SetMetatable(ud, timeMetatable(L))
+	return ud
+}
+
+// durations are seconds in lua, the same as os.time()
+func duration2LuaValue(d time.Duration) lua.LValue {
+	return lua.LNumber(d.Seconds())
+}
+
+// rv: userdata time, unix seconds or RFC3339 string
+func parseTime(rv reflect.Value) (time.Time, error) {
+	switch rv.Kind() {
+	case reflect.Int64:
+		return time.Unix(rv.Int(), 0), nil
+	case reflect.Float64:
+		sec, frac := math.Modf(rv.Float())
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	case reflect.String:
+		return time.Parse(time.RFC3339, rv.String())
+	case reflect.Struct:
+		if t, ok := rv.Interface().(time.Time); ok {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid value type, expect %v, got %s.", timeType, rv.Type())
+}
+
+// rv: seconds or string like "1h30m"
+func parseDuration(rv reflect.Value) (time.Duration, error) {
+	switch rv.Kind() {
+	case reflect.Int64:
+		return time.Duration(rv.Int()) * time.Second, nil
+	case reflect.Float64:
+		return time.Duration(rv.Float() * float64(time.Second)), nil
+	case reflect.String:
+		return time.ParseDuration(rv.String())
+	}
+	return 0, fmt.Errorf("invalid value type, expect %v, got %s.", durationType, rv.Type())
+}
+
+//==================================
+// RegisterTime registers the global 'time': time.now(), time.unix(sec), time.parse(value [, layout])
+// and the methods of userdata 'time'.
+func RegisterTime(L *lua.LState) {
+	L.SetGlobal(timeTypeName, timeMetatable(L))
+}
+
+// the metatable of userdata 'time', go2LuaValue creates it without the global
+func timeMetatable(L *lua.LState) lua.LValue {
+	if mt := L.GetTypeMetatable(timeTypeName); mt != lua.LNil {
+		return mt
+	}
+	mt := L.NewTypeMetatable(timeTypeName)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"unix": func(L2 *lua.LState) int {
+			L2.Push(lua.LNumber(checkTime(L2, 1).Unix()))
+			return 1
+		},
+		// t:format("2006-01-02 15:04:05")
+		"format": func(L2 *lua.LState) int {
+			L2.Push(lua.LString(checkTime(L2, 1).Format(L2.OptString(2, time.RFC3339))))
+			return 1
+		},
+		"add": func(L2 *lua.LState) int {
+			L2.Push(time2LuaValue(L2, checkTime(L2, 1).Add(checkDuration(L2, 2))))
+			return 1
+		},
+		"sub": func(L2 *lua.LState) int {
+			L2.Push(duration2LuaValue(checkTime(L2, 1).Sub(checkTime(L2, 2))))
+			return 1
+		},
+		"utc": func(L2 *lua.LState) int {
+			L2.Push(time2LuaValue(L2, checkTime(L2, 1).UTC()))
+			return 1
+		},
+	}))
+	L.SetFuncs(mt, map[string]lua.LGFunction{
+		"__tostring": func(L2 *lua.LState) int {
+			L2.Push(lua.LString(checkTime(L2, 1).Format(time.RFC3339)))
+			return 1
+		},
+		"__eq": func(L2 *lua.LState) int {
+			L2.Push(lua.LBool(checkTime(L2, 1).Equal(checkTime(L2, 2))))
+			return 1
+		},
+		"__lt": func(L2 *lua.LState) int {
+			L2.Push(lua.LBool(checkTime(L2, 1).Before(checkTime(L2, 2))))
+			return 1
+		},
+		"__le": func(L2 *lua.LState) int {
+			L2.Push(lua.LBool(!checkTime(L2, 1).After(checkTime(L2, 2))))
+			return 1
+		},
+		// t + 60, t + "1h"
+		"__add": func(L2 *lua.LState) int {
+			L2.Push(time2LuaValue(L2, checkTime(L2, 1).Add(checkDuration(L2, 2))))
+			return 1
+		},
+		// t1 - t2 --> seconds, t - 60 --> time
+		"__sub": func(L2 *lua.LState) int {
+			if ud, ok := L2.Get(2).(*lua.LUserData); ok && ud.Metatable == L2.GetTypeMetatable(timeTypeName) {
+				L2.Push(duration2LuaValue(checkTime(L2, 1).Sub(checkTime(L2, 2))))
+			} else {
+				L2.Push(time2LuaValue(L2, checkTime(L2, 1).Add(-checkDuration(L2, 2))))
+			}
+			return 1
+		},
+		"now": func(L2 *lua.LState) int {
+			L2.Push(time2LuaValue(L2, time.Now()))
+			return 1
+		},
+		"unix": func(L2 *lua.LState) int {
+			t, _ := parseTime(reflect.ValueOf(float64(L2.CheckNumber(1))))
+			L2.Push(time2LuaValue(L2, t))
+			return 1
+		},
+		"parse": func(L2 *lua.LState) int {
+			t, err := time.Parse(L2.OptString(2, time.RFC3339), L2.CheckString(1))
+			if err != nil {
+				L2.Push(lua.LNil)
+				L2.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L2.Push(time2LuaValue(L2, t))
+			return 1
+		},
+	})
+	return mt
+}
+
+func checkTime(L *lua.LState, n int) time.Time {
+	if ud, ok := L.Get(n).(*lua.LUserData); ok {
+		if t, ok := ud.Value.(time.Time); ok {
+			return t
+		}
+	}
+	L.ArgError(n, "time expected")
+	return time.Time{}
+}
+
+func checkDuration(L *lua.LState, n int) time.Duration {
+	rv := lua2GoValue(L, L.CheckAny(n))
+	if !rv.IsValid() {
+		L.ArgError(n, "duration expected")
+	}
+	d, err := parseDuration(rv)
+	if err != nil {
+		L.ArgError(n, err.Error())
+	}
+	return d
+}
@@ -0,0 +1,56 @@
+//
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestTimeConversion(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+
+	base := time.Date(2018, 6, 1, 8, 0, 0, 0, time.UTC)
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(struct {
+		Base    func() time.Time
+		Later   func(time.Time, time.Duration) time.Time
+		Timeout func() time.Duration
+	}{
+		Base:    func() time.Time { return base },
+		Later:   func(t time.Time, d time.Duration) time.Time { return t.Add(d) },
+		Timeout: func() time.Duration { return 90 * time.Second },
+	}, funcs)
+	for k, f := range funcs {
+		L.SetGlobal(k, L.NewFunction(f))
+	}
+
+	RegisterTime(L)
+	if err := L.DoString(`
+    local t = base()
+    assert(type(t)=='userdata')
+    assert(tostring(t)=='2018-06-01T08:00:00Z')
+    assert(t:format('2006-01-02')=='2018-06-01')
+    assert(t:unix()==1527840000)
+
+    assert(later(t, '5m') == t + 300)
+    assert(later(t, 60) - t == 60)
+    assert(t < later(t, '1s'))
+    assert(later(1527840000, '1h'):format('15:04')=='09:00')
+    assert(later('2018-06-01T08:00:00Z', 1) == t + '1s')
+
+    assert(timeout()==90)
+    assert(time.unix(1527840000) == t)
+    `); err != nil {
+		t.Fatal(err)
+	}
+
+	SetTimeMode(L, TimeAsUnix)
+	RegisterTime(L)
+	if err := L.DoString(`assert(base()==1527840000)`); err != nil {
+		t.Fatal(err)
+	}
+}
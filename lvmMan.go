@@ -4,10 +4,13 @@
 package base
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"sync"
 
 	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
 )
 
 const (
@@ -21,16 +24,30 @@ var (
 type PreloadFunc func(L *lua.LState) error
 
 func exec(L *lua.LState, script string, isFile bool, preload PreloadFunc) error {
+	return execChunk(L, preload, func() (*lua.LFunction, error) {
+		if isFile {
+			return L.LoadFile(script)
+		}
+		return L.LoadString(script)
+	})
+}
+
+// load is called after preload, args are the ... of the chunk
+func execChunk(L *lua.LState, preload PreloadFunc, load func() (*lua.LFunction, error), args ...lua.LValue) error {
 	if preload != nil {
 		if err := preload(L); err != nil {
 			return err
 		}
 	}
-	if isFile {
-		return L.DoFile(script)
-	} else {
-		return L.DoString(script)
+	fn, err := load()
+	if err != nil {
+		return err
+	}
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
 	}
+	return L.PCall(len(args), lua.MultRet, nil)
 }
 
 func DoScriptOnce(script string, preload PreloadFunc) error {
@@ -44,6 +61,20 @@ func DoFileOnce(filepath string, preload PreloadFunc) error {
 	return exec(L, filepath, true, preload)
 }
 
+// compileFile compiles the script once, NewFunctionFromProto runs it in any LState
+func compileFile(path string) (*lua.FunctionProto, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunk, err := parse.Parse(bufio.NewReader(f), path)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, path)
+}
+
 //preload can be nil
 func DoScriptInLuaVM(id int, script string, preload PreloadFunc) error {
 	return defaultLVMs.DoScriptInLuaVM(id, script, preload)
@@ -54,16 +85,22 @@ func DoFileInLuaVM(id int, filepath string, preload PreloadFunc) error {
 }
 
 //--------------------------------------------
+// the scripts of a vm run one at a time, a LState isn't goroutine-safe
+type lvm struct {
+	L  *lua.LState
+	mu sync.Mutex
+}
+
 type VMManage struct {
-	vms map[int]*lua.LState
+	vms map[int]*lvm
 	wlk sync.Mutex
 }
 
 func NewVMManager() *VMManage {
-	return &VMManage{vms: make(map[int]*lua.LState)}
+	return &VMManage{vms: make(map[int]*lvm)}
 }
 
-func (m *VMManage) getLVM(id int) (*lua.LState, error) {
+func (m *VMManage) getLVM(id int) (*lvm, error) {
 	m.wlk.Lock()
 	defer m.wlk.Unlock()
 
@@ -72,36 +109,45 @@ func (m *VMManage) getLVM(id int) (*lua.LState, error) {
 		if len(m.vms) > MAX_LVM_NUM {
 			return nil, fmt.Errorf("Too many virtual machines. limited=%d", MAX_LVM_NUM)
 		}
-		vm = lua.NewState(lua.Options{IncludeGoStackTrace: true})
+		vm = &lvm{L: lua.NewState(lua.Options{IncludeGoStackTrace: true})}
 		m.vms[id] = vm
 	}
 	return vm, nil
 }
 
+// withLVM runs f with the vm id locked, so the runs of a vm never overlap
+func (m *VMManage) withLVM(id int, f func(L *lua.LState) error) error {
+	vm, err := m.getLVM(id)
+	if err != nil {
+		return err
+	}
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	return f(vm.L)
+}
+
+// RemoveLVM closes the vm after its running script
 func (m *VMManage) RemoveLVM(id int) {
 	m.wlk.Lock()
-	defer m.wlk.Unlock()
+	vm, ok := m.vms[id]
+	delete(m.vms, id)
+	m.wlk.Unlock()
 
-	if L, ok := m.vms[id]; !ok {
-		L.Close()
-		delete(m.vms, id)
+	if ok {
+		vm.mu.Lock()
+		defer vm.mu.Unlock()
+		vm.L.Close()
 	}
 }
 
 func (m *VMManage) DoScriptInLuaVM(id int, script string, preload PreloadFunc) error {
-	L, err := m.getLVM(id)
-	if err != nil {
-		return err
-	}
-
-	return exec(L, script, false, preload)
+	return m.withLVM(id, func(L *lua.LState) error {
+		return exec(L, script, false, preload)
+	})
 }
 
 func (m *VMManage) DoFileInLuaVM(id int, filepath string, preload PreloadFunc) error {
-	L, err := m.getLVM(id)
-	if err != nil {
-		return err
-	}
-
-	return exec(L, filepath, true, preload)
+	return m.withLVM(id, func(L *lua.LState) error {
+		return exec(L, filepath, true, preload)
+	})
 }
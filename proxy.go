@@ -0,0 +1,186 @@
+//Lua.go
+
+//Expose large golang slices and maps to lua by a proxy userdata, without copying them to tables
+package base
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/yuin/gopher-lua"
+)
+
+const proxyTypeName = "proxy"
+
+// SetProxyThreshold makes go2LuaValue push the slices and maps whose length
+// is at least n as userdata 'proxy' in L, which reads and writes the golang value
+// directly: p[i], p[k] = v, #p, p:pairs() and p:ipairs(). 0 disables it, the default.
+func SetProxyThreshold(L *lua.LState, n int) {
+	settingsOf(L).proxyThreshold = n
+}
+
+type goProxy struct {
+	rv reflect.Value // Slice or Map
+}
+
+// slices and maps with at least threshold elements are pushed as proxies, 0 disables it
+func needProxy(rv reflect.Value, threshold int) bool {
+	if threshold <= 0 {
+		return false
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return !rv.IsNil() && rv.Len() >= threshold
+	}
+	return false
+}
+
+// NewProxy pushes the slice or map v as userdata 'proxy' regardless of the threshold.
+func NewProxy(L *lua.LState, v interface{}) lua.LValue {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Map {
+		L.RaiseError("NewProxy: expect slice or map, got %v.", rv.Kind())
+	}
+	if L.GetTypeMetatable(proxyTypeName) == lua.LNil {
+		registerProxy(L)
+	}
+	ud := L.NewUserData()
+	ud.Value = &goProxy{rv}
+	L.SetMetatable(ud, L.GetTypeMetatable(proxyTypeName))
+	return ud
+}
+
+func registerProxy(L *lua.LState) {
+	mt := L.NewTypeMetatable(proxyTypeName)
+	// the pairs/ipairs of lua 5.1 ignore __pairs, so they are methods: p:pairs(), p:ipairs()
+	methods := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"pairs":  proxyPairs,
+		"ipairs": proxyPairs,
+	})
+	L.SetFuncs(mt, map[string]lua.LGFunction{
+		"__index": func(L2 *lua.LState) int {
+			p := checkProxy(L2, 1)
+			key := L2.CheckAny(2)
+			if p.rv.Kind() == reflect.Slice {
+				if _, ok := key.(lua.LNumber); !ok {
+					L2.Push(methods.RawGet(key))
+					return 1
+				}
+				i := checkIndex(L2, 2)
+				if i < 1 || i > p.rv.Len() {
+					L2.Push(lua.LNil)
+					return 1
+				}
+				L2.Push(go2LuaValue(L2, p.rv.Index(i-1)))
+				return 1
+			}
+			// the keys of the map hide the methods
+			if k, err := ParseLValue(L2, key, p.rv.Type().Key()); err == nil && k.IsValid() {
+				if v := p.rv.MapIndex(k); v.IsValid() {
+					L2.Push(go2LuaValue(L2, v))
+					return 1
+				}
+			}
+			L2.Push(methods.RawGet(key))
+			return 1
+		},
+		"__newindex": func(L2 *lua.LState) int {
+			p := checkProxy(L2, 1)
+			if p.rv.Kind() == reflect.Slice {
+				i := checkIndex(L2, 2)
+				if i < 1 || i > p.rv.Len() {
+					L2.ArgError(2, fmt.Sprintf("index out of range [1, %d].", p.rv.Len()))
+				}
+				v, err := ParseLValue(L2, L2.CheckAny(3), p.rv.Type().Elem())
+				if err != nil {
+					L2.ArgError(3, err.Error())
+				}
+				p.rv.Index(i - 1).Set(proxyValue(v, p.rv.Type().Elem()))
+				return 0
+			}
+			k, err := ParseLValue(L2, L2.CheckAny(2), p.rv.Type().Key())
+			if err != nil {
+				L2.ArgError(2, err.Error())
+			}
+			if L2.Get(3) == lua.LNil {
+				p.rv.SetMapIndex(k, reflect.Value{}) //delete
+				return 0
+			}
+			v, err := ParseLValue(L2, L2.CheckAny(3), p.rv.Type().Elem())
+			if err != nil {
+				L2.ArgError(3, err.Error())
+			}
+			p.rv.SetMapIndex(k, proxyValue(v, p.rv.Type().Elem()))
+			return 0
+		},
+		"__len": func(L2 *lua.LState) int {
+			L2.Push(lua.LNumber(checkProxy(L2, 1).rv.Len()))
+			return 1
+		},
+		"__tostring": func(L2 *lua.LState) int {
+			p := checkProxy(L2, 1)
+			L2.Push(lua.LString(fmt.Sprintf("proxy: %v, len=%d", p.rv.Type(), p.rv.Len())))
+			return 1
+		},
+	})
+}
+
+// iterate the live value, the keys of a map are taken when pairs() is called
+func proxyPairs(L *lua.LState) int {
+	p := checkProxy(L, 1)
+	var next lua.LGFunction
+	if p.rv.Kind() == reflect.Slice {
+		i := 0
+		next = func(L2 *lua.LState) int {
+			if i++; i > p.rv.Len() {
+				return 0
+			}
+			L2.Push(lua.LNumber(i))
+			L2.Push(go2LuaValue(L2, p.rv.Index(i-1)))
+			return 2
+		}
+	} else {
+		keys, i := p.rv.MapKeys(), 0
+		next = func(L2 *lua.LState) int {
+			for ; i < len(keys); i++ {
+				if v := p.rv.MapIndex(keys[i]); v.IsValid() {
+					i++
+					L2.Push(go2LuaValue(L2, keys[i-1]))
+					L2.Push(go2LuaValue(L2, v))
+					return 2
+				}
+			}
+			return 0
+		}
+	}
+	L.Push(L.NewFunction(next))
+	L.Push(L.Get(1))
+	L.Push(lua.LNil)
+	return 3
+}
+
+// the 1-based index of a slice, 1.5 isn't truncated
+func checkIndex(L *lua.LState, n int) int {
+	f := float64(L.CheckNumber(n))
+	if f != math.Trunc(f) {
+		L.ArgError(n, fmt.Sprintf("index must be an integer, got %v.", f))
+	}
+	return int(f)
+}
+
+func checkProxy(L *lua.LState, n int) *goProxy {
+	if p, ok := L.CheckUserData(n).Value.(*goProxy); ok {
+		return p
+	}
+	L.ArgError(n, "proxy expected")
+	return nil
+}
+
+// nil --> the zero value of the element
+func proxyValue(v reflect.Value, expect reflect.Type) reflect.Value {
+	if !v.IsValid() {
+		return reflect.Zero(expect)
+	}
+	return v
+}
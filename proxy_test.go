@@ -0,0 +1,76 @@
+//
+
+package base
+
+import (
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestProxy(t *testing.T) {
+	L := lua.NewState(lua.Options{IncludeGoStackTrace: true})
+	defer L.Close()
+	SetProxyThreshold(L, 3)
+
+	nums := []int{10, 20, 30, 40}
+	scores := map[string]int{"a": 1, "b": 2, "c": 3}
+	funcs := make(map[string]lua.LGFunction)
+	ParseStruct(struct {
+		Nums   func() []int
+		Scores func() map[string]int
+		Small  func() []int
+		Sum    func([]int) int
+		Tags   func() map[interface{}]int
+	}{
+		Nums:   func() []int { return nums },
+		Scores: func() map[string]int { return scores },
+		Small:  func() []int { return []int{1, 2} },
+		Tags:   func() map[interface{}]int { return map[interface{}]int{"a": 1, "b": 2, "c": 3} },
+		Sum: func(ns []int) int {
+			s := 0
+			for _, n := range ns {
+				s += n
+			}
+			return s
+		},
+	}, funcs)
+	for k, f := range funcs {
+		L.SetGlobal(k, L.NewFunction(f))
+	}
+
+	if err := L.DoString(`
+    local ns = nums()
+    assert(type(ns)=='userdata')
+    assert(#ns==4 and ns[2]==20 and ns[5]==nil)
+    ns[1] = 11
+    local s = 0
+    for i, v in ns:ipairs() do s = s + v end
+    assert(s==101)
+    assert(sum(ns)==101)
+    assert(not pcall(function() ns[9] = 1 end))
+    assert(not pcall(function() return ns[1.5] end))
+    assert(not pcall(function() ns[1.5] = 1 end))
+
+    local sc = scores()
+    assert(sc.b==2 and sc.z==nil)
+    local tags = tags()
+    assert(tags.a==1 and tags[nil]==nil)
+    sc.d = 4
+    sc.a = nil
+    local n = 0
+    for k, v in sc:pairs() do n = n + v end
+    assert(n==9)
+
+    assert(type(small())=='table')
+    for k, v in pairs({x=1}) do assert(k=='x') end
+    `); err != nil {
+		t.Fatal(err)
+	}
+	if nums[0] != 11 || scores["d"] != 4 {
+		t.Fatalf("the proxy didn't write through: %v %v", nums, scores)
+	}
+	if _, ok := scores["a"]; ok {
+		t.Fatal("the proxy didn't delete the key")
+	}
+}
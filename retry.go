@@ -0,0 +1,93 @@
+//Lua.go
+
+//Retry the failed crontab jobs with a fixed or exponential backoff
+package base
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	BackoffFixed       = "fixed"       // wait delay between the attempts
+	BackoffExponential = "exponential" // wait delay*2^n with jitter
+
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = 10 * time.Minute
+)
+
+type retryPolicy struct {
+	retries     int
+	exponential bool
+	delay       time.Duration
+	retryOn     *regexp.Regexp // nil to retry on any error
+}
+
+func (rj RawJob) retryPolicy() (retryPolicy, error) {
+	p := retryPolicy{retries: rj.Retries, delay: defaultRetryDelay}
+	if rj.Retries < 0 {
+		return p, fmt.Errorf("Invalid retries %d.", rj.Retries)
+	}
+	switch rj.Backoff {
+	case "", BackoffFixed:
+	case BackoffExponential:
+		p.exponential = true
+	default:
+		return p, fmt.Errorf("Invalid backoff %s, expect fixed or exponential.", rj.Backoff)
+	}
+	if len(rj.Delay) > 0 {
+		d, err := parseDelay(rj.Delay)
+		if err != nil {
+			return p, err
+		}
+		p.delay = d
+	}
+	if len(rj.RetryOn) > 0 {
+		re, err := regexp.Compile(rj.RetryOn)
+		if err != nil {
+			return p, fmt.Errorf("Invalid retryOn: %v", err)
+		}
+		p.retryOn = re
+	}
+	return p, nil
+}
+
+// "30s" or the seconds, delay=5 in the crontab
+func parseDelay(s string) (time.Duration, error) {
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid delay %s.", s)
+	}
+	return d, nil
+}
+
+// attempt is the number of the failed attempts
+func (p retryPolicy) shouldRetry(attempt int, err error) bool {
+	return attempt <= p.retries && (p.retryOn == nil || p.retryOn.MatchString(err.Error()))
+}
+
+// the wait before the next attempt
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	if !p.exponential {
+		return p.delay
+	}
+	d := p.delay
+	for i := 1; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	// equal jitter: [d/2, d)
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
@@ -0,0 +1,92 @@
+//Lua.go
+
+//The convention of mapping the outputs of golang functions to lua returns
+package base
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// ErrorPolicy decides how a non-nil error, the last output of a function, is returned to lua.
+type ErrorPolicy int
+
+const (
+	ErrorAsReturn ErrorPolicy = iota // nil..., "error message"
+	ErrorAsRaise                     // a lua error, pcall gets the message
+)
+
+// SetErrorPolicy sets the ErrorPolicy of L, ErrorAsReturn by default
+func SetErrorPolicy(L *lua.LState, policy ErrorPolicy) {
+	settingsOf(L).errorPolicy = policy
+}
+
+// the options of a function bound by ParseStruct, from the tag of the struct field
+type callOptions struct {
+	expand bool // `lua:"expand"`, the slice output is expanded to multiple returns
+}
+
+func parseCallOptions(tag reflect.StructTag) (opts callOptions) {
+	for _, o := range strings.Split(tag.Get("lua"), ",") {
+		switch strings.TrimSpace(o) {
+		case "expand":
+			opts.expand = true
+		}
+	}
+	return
+}
+
+// The outputs of a golang function in lua:
+//
+//	(T, bool)          comma-ok, nil, false when it isn't ok
+//	(T, U, ..., error) T, U, ..., nil; a non-nil error follows the ErrorPolicy
+//	`lua:"expand"`     the last output except the error, a slice, is expanded to
+//	                   multiple returns, and the nil error is omitted
+//
+// and one lua value for each output in any other case.
+func setOutputs(L *lua.LState, f reflect.Type, vs []reflect.Value, opts callOptions) int {
+	numOut := f.NumOut()
+	if len(vs) != numOut {
+		return SetErrorOutputs(L, numOut, fmt.Errorf("invalid outputs."))
+	}
+
+	last, hasErr := numOut-1, numOut > 0 && f.Out(numOut-1) == errorInterface
+	if hasErr {
+		if !vs[last].IsNil() {
+			err := vs[last].Interface().(error)
+			if settingsOf(L).errorPolicy == ErrorAsRaise {
+				L.RaiseError("%s", err.Error())
+				return 0
+			}
+			return SetErrorOutputs(L, numOut, err)
+		}
+		last--
+	}
+
+	if numOut == 2 && !hasErr && f.Out(1).Kind() == reflect.Bool && !vs[1].Bool() {
+		L.Push(lua.LNil)
+		L.Push(lua.LFalse)
+		return 2
+	}
+
+	if opts.expand && last >= 0 && isExpandable(f.Out(last)) {
+		PushLValue(L, vs[:last]...)
+		n := vs[last].Len()
+		for i := 0; i < n; i++ {
+			PushLValue(L, vs[last].Index(i))
+		}
+		return last + n
+	}
+	return PushLValue(L, vs...)
+}
+
+func isExpandable(tp reflect.Type) bool {
+	switch tp.Kind() {
+	case reflect.Slice, reflect.Array:
+		return !isBytesType(tp)
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+//Lua.go
+
+//The conversion settings of a LState, kept in its registry
+package base
+
+import (
+	"github.com/yuin/gopher-lua"
+)
+
+const settingsKey = "luaApi.settings"
+
+// the settings are per LState, shared with its coroutines, so the VMs and the
+// libraries using this package don't change each other's conversions
+type stateSettings struct {
+	integerMode IntegerMode
+	timeMode    TimeMode
+
+	maxConvDepth    int
+	maxConvElements int
+	proxyThreshold  int
+	errorPolicy     ErrorPolicy
+}
+
+func defaultSettings() *stateSettings {
+	return &stateSettings{
+		integerMode: IntegerAsNumber,
+		timeMode:    TimeAsUserData,
+
+		maxConvDepth: defaultConvDepth,
+		errorPolicy:  ErrorAsReturn,
+	}
+}
+
+// the settings of L, the defaults if none is set
+func settingsOf(L *lua.LState) *stateSettings {
+	reg, ok := L.Get(lua.RegistryIndex).(*lua.LTable)
+	if !ok {
+		return defaultSettings()
+	}
+	if ud, ok := reg.RawGetString(settingsKey).(*lua.LUserData); ok {
+		if s, ok := ud.Value.(*stateSettings); ok {
+			return s
+		}
+	}
+	s := defaultSettings()
+	ud := L.NewUserData()
+	ud.Value = s
+	reg.RawSetString(settingsKey, ud)
+	return s
+}
@@ -0,0 +1,306 @@
+//Lua.go
+
+//Generate EmmyLua(LuaLS) annotation stubs and markdown docs for the registered modules
+package base
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// WriteLuaStub writes a LuaLS/EmmyLua meta file describing the module that
+// FuncModule(api) builds under the name 'name', plus the userdata types registered
+// by RegisterUserData(L, uds[i]).
+func WriteLuaStub(w io.Writer, name string, api LuaModuler, uds ...interface{}) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "---@meta %s\n\n", name)
+
+	fmt.Fprintf(bw, "---@class %s\n", name)
+	for _, k := range sortedKeys(api.Globals()) {
+		fmt.Fprintf(bw, "---@field %s string\n", k)
+	}
+	consts := moduleConstants(api)
+	for _, k := range sortedConstKeys(consts) {
+		fmt.Fprintf(bw, "---@field %s %s\n", k, luaValueTypeName(consts[k]))
+	}
+	fmt.Fprintf(bw, "local %s = {}\n", name)
+
+	sigs := moduleSignatures(api)
+	for _, fn := range sortedFuncNames(api) {
+		bw.WriteString("\n")
+		writeStubFunc(bw, name+"."+fn, sigs[fn], false)
+	}
+
+	for _, ud := range uds {
+		bw.WriteString("\n")
+		writeStubUserData(bw, ud)
+	}
+	fmt.Fprintf(bw, "\nreturn %s\n", name)
+	return bw.Flush()
+}
+
+// WriteLuaDoc writes a markdown reference of the same content as WriteLuaStub.
+func WriteLuaDoc(w io.Writer, name string, api LuaModuler, uds ...interface{}) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n\n", name)
+	fmt.Fprintf(bw, "```lua\nlocal %s = require(\"%s\")\n```\n", name, name)
+
+	if globals := api.Globals(); len(globals) > 0 {
+		bw.WriteString("\n## Globals\n\n| Name | Type | Value |\n|------|------|-------|\n")
+		for _, k := range sortedKeys(globals) {
+			fmt.Fprintf(bw, "| `%s` | string | `%s` |\n", k, globals[k])
+		}
+	}
+
+	if consts := moduleConstants(api); len(consts) > 0 {
+		bw.WriteString("\n## Constants\n\n| Name | Type | Value |\n|------|------|-------|\n")
+		for _, k := range sortedConstKeys(consts) {
+			fmt.Fprintf(bw, "| `%s` | %s | `%s` |\n", k, luaValueTypeName(consts[k]), tipsString(consts[k]))
+		}
+	}
+
+	sigs := moduleSignatures(api)
+	if fns := sortedFuncNames(api); len(fns) > 0 {
+		bw.WriteString("\n## Functions\n")
+		for _, fn := range fns {
+			writeDocFunc(bw, name+"."+fn, sigs[fn])
+		}
+	}
+
+	for _, ud := range uds {
+		tps := userDataType(ud)
+		tpName := lowerTypeName(reflect.Zero(tps).Interface())
+		fmt.Fprintf(bw, "\n## userdata %s\n\n", tpName)
+		fmt.Fprintf(bw, "Go type `%s`.%s\n", tps, tipsUsage(tps))
+		bw.WriteString("\n| Method | Params | Returns |\n|--------|--------|---------|\n")
+		for _, field := range exportedFields(tps) {
+			fmt.Fprintf(bw, "| `%s:get%s()` | | %s |\n", tpName, field.Name, luaTypeName(field.Type))
+			fmt.Fprintf(bw, "| `%s:set%s(v)` | v: %s | |\n", tpName, field.Name, luaTypeName(field.Type))
+		}
+	}
+	return bw.Flush()
+}
+
+//==================================
+func writeStubFunc(w *bufio.Writer, fullName string, tp reflect.Type, method bool) {
+	sep := "."
+	if method {
+		sep = ":"
+	}
+	if tp == nil {
+		// LGFunction without Go signature
+		fmt.Fprintf(w, "---@param ... any\n---@return any\nfunction %s(...) end\n", fullName)
+		return
+	}
+	fmt.Fprintf(w, "---%s\n", funcSignature(fullName[strings.LastIndex(fullName, sep)+1:], tp))
+	params := make([]string, 0, tp.NumIn())
+	for i := 0; i < tp.NumIn(); i++ {
+		if tp.IsVariadic() && i == tp.NumIn()-1 {
+			fmt.Fprintf(w, "---@param ... %s\n", luaTypeName(tp.In(i).Elem()))
+			params = append(params, "...")
+		} else {
+			fmt.Fprintf(w, "---@param p%d %s\n", i+1, luaTypeName(tp.In(i)))
+			params = append(params, fmt.Sprintf("p%d", i+1))
+		}
+	}
+	for i := 0; i < tp.NumOut(); i++ {
+		fmt.Fprintf(w, "---@return %s\n", luaTypeName(tp.Out(i)))
+	}
+	fmt.Fprintf(w, "function %s(%s) end\n", fullName, strings.Join(params, ", "))
+}
+
+func writeStubUserData(w *bufio.Writer, ud interface{}) {
+	tps := userDataType(ud)
+	tpName := lowerTypeName(reflect.Zero(tps).Interface())
+	fields := exportedFields(tps)
+
+	fmt.Fprintf(w, "---@class %s\n", tpName)
+	fmt.Fprintf(w, "%s = {}\n\n", tpName)
+
+	params := make([]string, 0, tps.NumField())
+	for i := 0; i < tps.NumField(); i++ {
+		fmt.Fprintf(w, "---@param p%d? %s\n", i+1, luaTypeName(tps.Field(i).Type))
+		params = append(params, fmt.Sprintf("p%d", i+1))
+	}
+	fmt.Fprintf(w, "---@return %s\n", tpName)
+	fmt.Fprintf(w, "function %s.new(%s) end\n", tpName, strings.Join(params, ", "))
+
+	for _, field := range fields {
+		fmt.Fprintf(w, "\n---@return %s\n", luaTypeName(field.Type))
+		fmt.Fprintf(w, "function %s:get%s() end\n", tpName, field.Name)
+		fmt.Fprintf(w, "\n---@param v %s\n", luaTypeName(field.Type))
+		fmt.Fprintf(w, "function %s:set%s(v) end\n", tpName, field.Name)
+	}
+}
+
+func writeDocFunc(w *bufio.Writer, fullName string, tp reflect.Type) {
+	fmt.Fprintf(w, "\n### %s\n\n", fullName)
+	if tp == nil {
+		w.WriteString("No Go signature available.\n")
+		return
+	}
+	fmt.Fprintf(w, "```go\n%s\n```\n", funcSignature(fullName, tp))
+	if tp.NumIn() > 0 {
+		w.WriteString("\n| Param | Type |\n|-------|------|\n")
+		for i := 0; i < tp.NumIn(); i++ {
+			if tp.IsVariadic() && i == tp.NumIn()-1 {
+				fmt.Fprintf(w, "| `...` | %s |\n", luaTypeName(tp.In(i).Elem()))
+			} else {
+				fmt.Fprintf(w, "| `p%d` | %s |\n", i+1, luaTypeName(tp.In(i)))
+			}
+		}
+	}
+	if tp.NumOut() > 0 {
+		w.WriteString("\nReturns:")
+		for i := 0; i < tp.NumOut(); i++ {
+			fmt.Fprintf(w, " %s", luaTypeName(tp.Out(i)))
+			if i < tp.NumOut()-1 {
+				w.WriteString(",")
+			}
+		}
+		w.WriteString("\n")
+	}
+}
+
+// name(int, ...string) (int, error)
+func funcSignature(name string, tp reflect.Type) string {
+	ins := make([]string, 0, tp.NumIn())
+	for i := 0; i < tp.NumIn(); i++ {
+		if tp.IsVariadic() && i == tp.NumIn()-1 {
+			ins = append(ins, "..."+tp.In(i).Elem().String())
+		} else {
+			ins = append(ins, tp.In(i).String())
+		}
+	}
+	outs := make([]string, 0, tp.NumOut())
+	for i := 0; i < tp.NumOut(); i++ {
+		outs = append(outs, tp.Out(i).String())
+	}
+	sig := name + "(" + strings.Join(ins, ", ") + ")"
+	switch len(outs) {
+	case 0:
+	case 1:
+		sig += " " + outs[0]
+	default:
+		sig += " (" + strings.Join(outs, ", ") + ")"
+	}
+	return sig
+}
+
+// golang type --> LuaLS type name
+func luaTypeName(tp reflect.Type) string {
+	switch tp {
+	case errorInterface:
+		return "string?"
+	case durationType:
+		return "number"
+	case bufferType:
+		return bufferTypeName
+	}
+	if isBytesType(tp) {
+		return "string"
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Ptr:
+		return luaTypeName(tp.Elem())
+	case reflect.Struct:
+		return lowerTypeName(reflect.Zero(tp).Interface())
+	case reflect.Slice, reflect.Array:
+		return luaTypeName(tp.Elem()) + "[]"
+	case reflect.Map:
+		return "table<" + luaTypeName(tp.Key()) + ", " + luaTypeName(tp.Elem()) + ">"
+	case reflect.Func:
+		return "function"
+	case reflect.Chan:
+		return channelTypeName
+	default:
+		return "any"
+	}
+}
+
+func luaValueTypeName(v interface{}) string {
+	if v == nil {
+		return "any"
+	}
+	return luaTypeName(reflect.TypeOf(v))
+}
+
+func moduleConstants(api LuaModuler) map[string]interface{} {
+	if c, ok := api.(LuaConstanter); ok {
+		return c.Constants()
+	}
+	return nil
+}
+
+// signatures of the functions in api.Funcs(), nil for those which aren't created by ParseStruct
+func moduleSignatures(api LuaModuler) map[string]reflect.Type {
+	if reflect.TypeOf(api).Kind() == reflect.Ptr && reflect.ValueOf(api).Elem().Kind() == reflect.Struct {
+		// FuncModule(&x) binds the methods of x, ParseStruct(x) in Funcs() binds the fields
+		sigs := FuncSignatures(reflect.ValueOf(api).Elem().Interface())
+		for k, v := range FuncSignatures(api) {
+			sigs[k] = v
+		}
+		return sigs
+	}
+	return FuncSignatures(api)
+}
+
+func sortedFuncNames(api LuaModuler) []string {
+	names := make([]string, 0)
+	for k := range api.Funcs() {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedConstKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func userDataType(demo interface{}) reflect.Type {
+	tps := reflect.TypeOf(demo)
+	if tps.Kind() == reflect.Ptr {
+		tps = tps.Elem()
+	}
+	return tps
+}
+
+func exportedFields(tps reflect.Type) []reflect.StructField {
+	fields := make([]reflect.StructField, 0, tps.NumField())
+	for i := 0; i < tps.NumField(); i++ {
+		fName := tps.Field(i).Name
+		if len(fName) == 0 || fName[0] < 'A' || fName[0] > 'Z' {
+			continue //ignore private fields
+		}
+		fields = append(fields, tps.Field(i))
+	}
+	return fields
+}
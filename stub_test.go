@@ -0,0 +1,57 @@
+//
+
+package base
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteLuaStub(t *testing.T) {
+	type ScorePair struct {
+		Score  int64
+		Member string
+	}
+
+	x := &xt{
+		Double: func(n int) int { return n * n },
+		StrCat: func(s ...string) string { return strings.Join(s, "") },
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteLuaStub(buf, "xt", x, ScorePair{}); err != nil {
+		t.Fatal(err)
+	}
+	stub := buf.String()
+	for _, want := range []string{
+		"---@meta xt",
+		"---@field flag string",
+		"---double(int) int\n---@param p1 integer\n---@return integer\nfunction xt.double(p1) end",
+		"---@param ... string\n---@return string\nfunction xt.strcat(...) end",
+		"---@class scorepair",
+		"function scorepair.new(p1, p2) end",
+		"---@return integer\nfunction scorepair:getScore() end",
+		"---@param v string\nfunction scorepair:setMember(v) end",
+	} {
+		if !strings.Contains(stub, want) {
+			t.Errorf("stub missing %q:\n%s", want, stub)
+		}
+	}
+
+	buf.Reset()
+	if err := WriteLuaDoc(buf, "xt", x, ScorePair{}); err != nil {
+		t.Fatal(err)
+	}
+	doc := buf.String()
+	for _, want := range []string{
+		"| `flag` | string | `globelsFlag` |",
+		"### xt.strcat",
+		"xt.strcat(...string) string",
+		"| `scorepair:getScore()` | | integer |",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("doc missing %q:\n%s", want, doc)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+//Lua.go
+
+//Watch the crontab file and the job scripts, reload them on change
+package base
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/yuin/gopher-lua"
+)
+
+const defaultDebounce = 500 * time.Millisecond
+
+// Watch loads cronFile, then reloads it whenever it changes until ctx is done. The
+// parent directories are watched, so the files replaced by editors are seen too.
+// With PrecompileScripts, the doFile scripts are precompiled for the jobs and recompiled
+// when they change, until Watch returns. A failed reload is passed to OnReload and the
+// running jobs are kept.
+func (c *Crontab) Watch(ctx context.Context, cronFile string, plf PreloadFunc) error {
+	if _, err := c.Load(cronFile, plf); err != nil {
+		return err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	defer c.clearProtos()
+
+	cronAbs := absPath(cronFile)
+	scripts := c.scriptPaths()
+	dirs := make(map[string]bool)
+	c.watchDirs(w, dirs, cronAbs, scripts)
+	c.precompile(scripts)
+
+	debounce := c.Debounce
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	cronChanged, changed := false, make(map[string]string)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			name := filepath.Clean(ev.Name)
+			if name == cronAbs {
+				cronChanged = true
+			} else if s, ok := scripts[name]; ok {
+				changed[name] = s
+			} else {
+				continue
+			}
+			timer.Reset(debounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			c.reloaded(LoadReport{}, err)
+		case <-timer.C:
+			if cronChanged {
+				report, err := c.Load(cronFile, plf)
+				c.reloaded(report, err)
+				if err == nil {
+					scripts = c.scriptPaths()
+					c.watchDirs(w, dirs, cronAbs, scripts)
+					c.precompile(scripts)
+					changed = make(map[string]string) //recompiled
+				}
+			}
+			c.precompile(changed)
+			cronChanged, changed = false, make(map[string]string)
+		}
+	}
+}
+
+func (c *Crontab) reloaded(report LoadReport, err error) {
+	if err != nil {
+		logger.Error("reload crontab: %v", err)
+	}
+	if c.OnReload != nil {
+		c.OnReload(report, err)
+	}
+}
+
+// abs path --> the path in the crontab
+func (c *Crontab) scriptPaths() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scripts := make(map[string]string, len(c.jobs))
+	for _, cj := range c.jobs {
+		scripts[absPath(cj.FilePath)] = cj.FilePath
+	}
+	return scripts
+}
+
+// watch the parent directories of the files, and stop watching the unused ones
+func (c *Crontab) watchDirs(w *fsnotify.Watcher, dirs map[string]bool, cronAbs string, scripts map[string]string) {
+	want := map[string]bool{filepath.Dir(cronAbs): true}
+	for abs := range scripts {
+		want[filepath.Dir(abs)] = true
+	}
+	for dir := range dirs {
+		if !want[dir] {
+			w.Remove(dir)
+			delete(dirs, dir)
+		}
+	}
+	for dir := range want {
+		if dirs[dir] {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			c.reloaded(LoadReport{}, err)
+			continue
+		}
+		dirs[dir] = true
+	}
+}
+
+func (c *Crontab) precompile(scripts map[string]string) {
+	if !c.PrecompileScripts {
+		return
+	}
+	for _, s := range scripts {
+		proto, err := compileFile(s)
+		if err != nil {
+			c.reloaded(LoadReport{}, err)
+		}
+		c.protoMu.Lock()
+		if c.protos == nil {
+			c.protos = make(map[string]*lua.FunctionProto)
+		}
+		if proto != nil {
+			c.protos[filepath.Clean(s)] = proto
+		} else {
+			delete(c.protos, filepath.Clean(s)) //the jobs fail by loading the file
+		}
+		c.protoMu.Unlock()
+	}
+}
+
+func (c *Crontab) cachedProto(path string) *lua.FunctionProto {
+	c.protoMu.RLock()
+	defer c.protoMu.RUnlock()
+	return c.protos[filepath.Clean(path)]
+}
+
+// c.mu is locked, the proto is dropped when no job runs the script
+func (c *Crontab) dropProto(path string) {
+	path = filepath.Clean(path)
+	for _, cj := range c.jobs {
+		if filepath.Clean(cj.FilePath) == path {
+			return
+		}
+	}
+	c.protoMu.Lock()
+	delete(c.protos, path)
+	c.protoMu.Unlock()
+}
+
+func (c *Crontab) clearProtos() {
+	c.protoMu.Lock()
+	c.protos = nil
+	c.protoMu.Unlock()
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,97 @@
+//
+
+package base
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestCrontabWatch(t *testing.T) {
+	dir := t.TempDir()
+	cronFile := filepath.Join(dir, "crontab.lua")
+	script := filepath.Join(dir, "job.lua")
+	write := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(script, "x = 1")
+	write(cronFile, "setJobs({{id='a', spec='@hourly', doFile='"+script+"'}})")
+
+	reloads := make(chan error, 10)
+	ct := Crontab{
+		Debounce:          20 * time.Millisecond,
+		PrecompileScripts: true,
+		OnReload: func(report LoadReport, err error) {
+			reloads <- err
+		},
+	}
+	defer ct.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		ct.Watch(ctx, cronFile, func(L *lua.LState) error { return nil })
+		close(done)
+	}()
+
+	wait := func() error {
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+			return nil
+		}
+	}
+	for i := 0; i < 100 && ct.cachedProto(script) == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	proto := ct.cachedProto(script)
+	if proto == nil {
+		t.Fatal("the script isn't precompiled")
+	}
+
+	write(cronFile, "setJobs({{id='a', spec='@daily', doFile='"+script+"'}})")
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if ct.jobs["a"].RawSpec != "@daily" {
+		t.Fatalf("not reloaded: %v", ct.jobs["a"])
+	}
+
+	write(cronFile, "setJobs({{id='a', spec='@sometimes'}})")
+	if err := wait(); err == nil {
+		t.Fatal("expect the invalid spec")
+	}
+	if ct.jobs["a"].RawSpec != "@daily" {
+		t.Fatal("the running job is torn down")
+	}
+
+	write(script, "x = ")
+	if err := wait(); err == nil {
+		t.Fatal("expect the compile error")
+	}
+	write(script, "x = 2")
+	time.Sleep(200 * time.Millisecond)
+	if p := ct.cachedProto(script); p == nil || p == proto {
+		t.Fatal("the script isn't recompiled")
+	}
+
+	ct.Stop()
+	if ct.cachedProto(script) != nil {
+		t.Fatal("the proto of the removed job is kept")
+	}
+	ct.precompile(map[string]string{script: script})
+	cancel()
+	<-done
+	if ct.cachedProto(script) != nil {
+		t.Fatal("the protos are kept after Watch")
+	}
+}